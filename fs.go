@@ -1,17 +1,24 @@
 package main
 
 import (
-	"errors"
-	"github.com/fsnotify/fsnotify"
-	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
+
+	"github.com/fsnotify/fsnotify"
 )
 
-func watchDirs(dirs []string, cb func()) {
+// How long to wait after the last change before calling back, so a burst of changes,
+// for example from saving several files at once, results in a single rebuild.
+const debounce = 100 * time.Millisecond
+
+// watchDirs calls cb with the paths of all files or directories that were created, written, renamed or removed
+// in the given directories or their subdirectories, or of the given files.
+// Changes are collected until no more changes happen for a short while, so the last change is never missed.
+func watchDirs(dirs []string, files []string, cb func(changed []string)) {
 	// Create new watcher.
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
@@ -21,64 +28,94 @@ func watchDirs(dirs []string, cb func()) {
 	defer watcher.Close()
 
 	for _, p := range dirs {
-		if err := filepath.WalkDir(p, func(f string, d fs.DirEntry, err error) error {
-			if !d.IsDir() {
-				return nil
-			}
-
-			return watcher.Add(f)
-		}); err != nil {
+		if err := watchRecursively(watcher, p); err != nil {
 			log.Fatal("error adding directory to watcher: %s", err)
 		}
 	}
 
-	// block thread indefinitely
-	triggered := time.Now()
-	for event := range watcher.Events {
-		if event.Has(fsnotify.Write) && time.Since(triggered) > 1*time.Second {
-			time.Sleep(100 * time.Millisecond)
-			triggered = time.Now()
-			cb()
+	// files are watched through their directory, as editors often replace a file instead of writing to it
+	watchedFiles := make(map[string]bool, len(files))
+	for _, f := range files {
+		watchedFiles[filepath.Clean(f)] = true
+		if err := watcher.Add(filepath.Dir(f)); err != nil {
+			log.Fatal("error adding file to watcher: %s", err)
 		}
 	}
-}
 
-func copyFile(src string, d fs.DirEntry, dest string) error {
-	// if it's a dir, just re-create it in build/
-	if d.IsDir() {
-		err := os.MkdirAll(dest, 0755)
-		if err != nil && !errors.Is(err, os.ErrExist) {
-			return err
-		}
+	changed := make(map[string]bool)
+	timer := time.NewTimer(debounce)
+	timer.Stop()
 
-		return nil
-	}
+	// block thread indefinitely
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
 
-	// open source file
-	in, err := os.Open(src)
-	if err != nil {
-		return err
-	}
-	defer in.Close()
+			name := filepath.Clean(event.Name)
+			if event.Op == fsnotify.Chmod || isTempFile(name) || !(watchedFiles[name] || inDirs(name, dirs)) {
+				continue
+			}
 
-	// create dest file
-	fh, err := os.Create(dest)
-	if err != nil {
-		return err
-	}
-	defer fh.Close()
+			// new directories are watched too, including any files created in them before they were watched
+			if event.Has(fsnotify.Create) {
+				if info, err := os.Stat(name); err == nil && info.IsDir() {
+					if err := watchRecursively(watcher, name); err != nil {
+						log.Warn("error adding directory to watcher: %s\n", err)
+					}
+				}
+			}
+
+			changed[name] = true
+			timer.Reset(debounce)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Warn("error watching files: %s\n", err)
+		case <-timer.C:
+			names := make([]string, 0, len(changed))
+			for name := range changed {
+				names = append(names, name)
+			}
+			slices.Sort(names)
+			clear(changed)
 
-	// copy src content into dest content
-	_, err = io.Copy(fh, in)
-	return err
+			// changes during the callback are picked up afterwards
+			cb(names)
+		}
+	}
 }
 
-func copyDirRecursively(src string, dst string) error {
-	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+// watchRecursively adds dir and all directories in it to the watcher.
+func watchRecursively(watcher *fsnotify.Watcher, dir string) error {
+	return filepath.WalkDir(dir, func(f string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
-		outpath := dst + strings.TrimPrefix(path, src)
-		return copyFile(path, d, outpath)
+		if !d.IsDir() {
+			return nil
+		}
+
+		return watcher.Add(f)
 	})
 }
+
+// inDirs reports whether the given path is one of the given directories or inside one of them.
+func inDirs(name string, dirs []string) bool {
+	for _, dir := range dirs {
+		dir = filepath.Clean(dir)
+		if name == dir || strings.HasPrefix(name, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isTempFile reports whether the given file is a hidden or backup file, as editors write while saving.
+func isTempFile(name string) bool {
+	base := filepath.Base(name)
+	return strings.HasPrefix(base, ".") || strings.HasSuffix(base, "~")
+}
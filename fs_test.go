@@ -0,0 +1,94 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestWatchDirs(t *testing.T) {
+	root := t.TempDir()
+	content := filepath.Join(root, "content")
+	config := filepath.Join(root, "config.toml")
+	if err := os.Mkdir(content, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan []string, 10)
+	go watchDirs([]string{content}, []string{config}, func(changed []string) {
+		ch <- changed
+	})
+	time.Sleep(50 * time.Millisecond)
+
+	// next returns all paths changed until no more changes are reported
+	next := func() []string {
+		var all []string
+		timeout := time.After(2 * time.Second)
+		for {
+			select {
+			case changed := <-ch:
+				all = append(all, changed...)
+			case <-time.After(3 * debounce):
+				if len(all) > 0 {
+					return all
+				}
+			case <-timeout:
+				t.Fatalf("Expected changes, got %v", all)
+			}
+		}
+	}
+
+	// a new directory is watched, along with the files created in it
+	dir := filepath.Join(content, "blog")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if changed := next(); !slices.Contains(changed, dir) {
+		t.Errorf("Expected %s to be reported, got %v", dir, changed)
+	}
+
+	tests := []struct {
+		name   string
+		change func(file string) error
+		file   string
+	}{
+		{name: "create in new directory", file: filepath.Join(dir, "post.md"), change: func(file string) error { return os.WriteFile(file, []byte("post"), 0655) }},
+		{name: "write", file: filepath.Join(dir, "post.md"), change: func(file string) error { return os.WriteFile(file, []byte("edited"), 0655) }},
+		{name: "rename", file: filepath.Join(dir, "post.md"), change: func(file string) error { return os.Rename(file, file+".bak") }},
+		{name: "remove", file: filepath.Join(dir, "post.md.bak"), change: os.Remove},
+		{name: "config", file: config, change: func(file string) error { return os.WriteFile(file, []byte("title = \"x\""), 0655) }},
+	}
+	for _, tc := range tests {
+		if err := tc.change(tc.file); err != nil {
+			t.Fatal(err)
+		}
+		if changed := next(); !slices.Contains(changed, tc.file) {
+			t.Errorf("%s: expected %s to be reported, got %v", tc.name, tc.file, changed)
+		}
+	}
+
+	// a burst of changes results in a single callback, after the last change
+	for i := 0; i < 5; i++ {
+		if err := os.WriteFile(filepath.Join(content, "burst.md"), []byte{byte(i)}, 0655); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(debounce / 4)
+	}
+	time.Sleep(3 * debounce)
+	if n := len(ch); n != 1 {
+		t.Errorf("Expected a single callback for a burst of changes, got %d", n)
+	}
+
+	// other files next to the configuration file are ignored
+	if err := os.WriteFile(filepath.Join(root, "notes.txt"), []byte("notes"), 0655); err != nil {
+		t.Fatal(err)
+	}
+	<-ch
+	select {
+	case changed := <-ch:
+		t.Errorf("Expected no changes, got %v", changed)
+	case <-time.After(3 * debounce):
+	}
+}
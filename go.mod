@@ -9,7 +9,12 @@ require github.com/yuin/goldmark v1.6.0
 require (
 	git.sr.ht/~ser/godjot/v2 v2.0.2
 	github.com/BurntSushi/toml v1.3.2
+	github.com/alecthomas/chroma/v2 v2.14.0
 	github.com/fsnotify/fsnotify v1.7.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
-require golang.org/x/sys v0.4.0 // indirect
+require (
+	github.com/dlclark/regexp2 v1.11.0 // indirect
+	golang.org/x/sys v0.4.0 // indirect
+)
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// redirectRule is a line in a _redirects file: "/from /to [status]".
+type redirectRule struct {
+	from   string
+	to     string
+	status int
+
+	// Whether the rule also applies if a file exists at its path, set with a "!" after the status
+	force bool
+}
+
+// headerRule is a path pattern in a _headers file with the headers on the indented lines below it.
+type headerRule struct {
+	pattern string
+	headers http.Header
+}
+
+// redirect returns the first rule in the _redirects file matching the given URL path and its target.
+func (s *devServer) redirect(urlPath string) (redirectRule, string, bool) {
+	for _, rule := range readRedirects(s.path("/_redirects")) {
+		if params, ok := matchPath(rule.from, urlPath); ok {
+			return rule, expandPath(rule.to, params), true
+		}
+	}
+	return redirectRule{}, "", false
+}
+
+// headers returns the headers from all rules in the _headers file matching the given URL path.
+func (s *devServer) headers(urlPath string) http.Header {
+	headers := make(http.Header)
+	for _, rule := range readHeaders(s.path("/_headers")) {
+		if _, ok := matchPath(rule.pattern, urlPath); ok {
+			for name, values := range rule.headers {
+				headers[name] = append(headers[name], values...)
+			}
+		}
+	}
+	return headers
+}
+
+// readRedirects reads the rules in the given _redirects file. A missing file has no rules.
+func readRedirects(file string) []redirectRule {
+	fh, err := os.Open(file)
+	if err != nil {
+		return nil
+	}
+	defer fh.Close()
+
+	var rules []redirectRule
+	scanner := bufio.NewScanner(fh)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		rule := redirectRule{from: fields[0], to: fields[1], status: http.StatusMovedPermanently}
+		if len(fields) > 2 {
+			status, force := strings.CutSuffix(fields[2], "!")
+			if code, err := strconv.Atoi(status); err == nil {
+				rule.status = code
+			}
+			rule.force = force
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules
+}
+
+// readHeaders reads the rules in the given _headers file. A missing file has no rules.
+func readHeaders(file string) []headerRule {
+	fh, err := os.Open(file)
+	if err != nil {
+		return nil
+	}
+	defer fh.Close()
+
+	var rules []headerRule
+	scanner := bufio.NewScanner(fh)
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		// unindented lines start a new rule, indented lines add a header to it
+		if line == trimmed {
+			rules = append(rules, headerRule{pattern: trimmed, headers: make(http.Header)})
+			continue
+		}
+		if name, value, ok := strings.Cut(trimmed, ":"); ok && len(rules) > 0 {
+			rules[len(rules)-1].headers.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+		}
+	}
+
+	return rules
+}
+
+// matchPath reports whether the given URL path matches the pattern and returns the values of its placeholders.
+// A ":name" segment matches any single segment, a trailing "*" matches the rest of the path as ":splat".
+// Trailing slashes are ignored.
+func matchPath(pattern string, urlPath string) (map[string]string, bool) {
+	patternSegments := strings.Split(strings.Trim(pattern, "/"), "/")
+	pathSegments := strings.Split(strings.Trim(urlPath, "/"), "/")
+
+	params := make(map[string]string)
+	for i, segment := range patternSegments {
+		if segment == "*" && i == len(patternSegments)-1 {
+			params["splat"] = strings.Join(pathSegments[i:], "/")
+			return params, true
+		}
+		if i >= len(pathSegments) {
+			return nil, false
+		}
+		if strings.HasPrefix(segment, ":") {
+			params[segment[1:]] = pathSegments[i]
+		} else if segment != pathSegments[i] {
+			return nil, false
+		}
+	}
+
+	return params, len(patternSegments) == len(pathSegments)
+}
+
+// expandPath replaces the placeholders in the given redirect target with their values.
+func expandPath(target string, params map[string]string) string {
+	segments := strings.Split(target, "/")
+	for i, segment := range segments {
+		if value, ok := params[strings.TrimPrefix(segment, ":")]; ok && strings.HasPrefix(segment, ":") {
+			segments[i] = value
+		}
+	}
+
+	// keep absolute URLs as they are
+	if strings.Contains(target, "://") {
+		return strings.Join(segments, "/")
+	}
+	return path.Clean(strings.Join(segments, "/")) + trailingSlash(target)
+}
+
+func trailingSlash(p string) string {
+	if strings.HasSuffix(p, "/") && p != "/" {
+		return "/"
+	}
+	return ""
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dannyvankooten/gozer/site"
+)
+
+// URL path of the server-sent events endpoint pages listen on for reloads
+const reloadPath = "/_gozer/reload"
+
+// reloadScript is injected into every HTML page served during development.
+// It reloads the page after a rebuild, or only its stylesheets if nothing but CSS changed.
+const reloadScript = `<script>
+(function() {
+	var events = new EventSource("` + reloadPath + `");
+	events.addEventListener("reload", function() { location.reload(); });
+	events.addEventListener("css", function() {
+		document.querySelectorAll('link[rel="stylesheet"]').forEach(function(link) {
+			var url = new URL(link.href);
+			url.searchParams.set("gozer", Date.now());
+			link.href = url.toString();
+		});
+	});
+})();
+</script>
+`
+
+// overlayTemplate shows the errors of the last build on top of the served page.
+var overlayTemplate = template.Must(template.New("overlay").Parse(`<div id="gozer-errors" style="position: fixed; inset: 0; z-index: 2147483647; overflow: auto; padding: 2em; background: rgba(20, 20, 20, 0.95); color: #eee; font: 14px/1.5 monospace;">
+<h2 style="color: #ff6b6b; margin-top: 0;">Build failed with {{ len . }} error(s)</h2>
+{{ range . }}<div style="margin-bottom: 1.5em;">
+<strong style="color: #ffd166;">{{ .File }}{{ if .Line }}:{{ .Line }}{{ end }}</strong>
+<pre style="white-space: pre-wrap; margin: 0.5em 0 0;">{{ .Message }}</pre>
+</div>{{ end }}
+</div>
+`))
+
+// buildProblem is an error of the last build, as shown in the overlay.
+type buildProblem struct {
+	File    string
+	Line    int
+	Message string
+}
+
+// devServer serves the output directory during development and tells open pages to reload after a rebuild.
+type devServer struct {
+	// Directory to serve files from
+	dir string
+
+	mu      sync.Mutex
+	clients map[chan string]bool
+
+	// Errors of the last build, shown on every page until a build succeeds
+	problems []buildProblem
+}
+
+func newDevServer(dir string) *devServer {
+	return &devServer{
+		dir:     dir,
+		clients: make(map[chan string]bool),
+	}
+}
+
+// Content types for files whose type differs between systems, or that browsers need to display them properly
+var contentTypes = map[string]string{
+	".html": "text/html; charset=utf-8",
+	".xml":  "application/xml; charset=utf-8",
+	".xsl":  "text/xsl; charset=utf-8",
+}
+
+// ServeHTTP serves the output directory like a static host would: without directory listings,
+// with the 404.html page for missing files and with the rules in the _headers and _redirects files.
+func (s *devServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == reloadPath {
+		s.serveEvents(w, r)
+		return
+	}
+
+	urlPath := path.Clean("/" + r.URL.Path)
+	if strings.HasSuffix(r.URL.Path, "/") && urlPath != "/" {
+		urlPath += "/"
+	}
+
+	// directories are served at their URL with a trailing slash
+	if info, err := os.Stat(s.path(urlPath)); err == nil && info.IsDir() && !strings.HasSuffix(urlPath, "/") {
+		target := urlPath + "/"
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+		return
+	}
+
+	// redirect rules apply to paths without a file, unless they are forced
+	file, found := s.resolve(urlPath)
+	status := http.StatusOK
+	if rule, target, ok := s.redirect(urlPath); ok && (!found || rule.force) {
+		// rules with status 200 or 404 serve the target with that status instead of redirecting
+		if rule.status != http.StatusOK && rule.status != http.StatusNotFound {
+			http.Redirect(w, r, target, rule.status)
+			return
+		}
+		status = rule.status
+		file, found = s.resolve(target)
+	}
+
+	if !found {
+		// a page that failed to build may not exist at all
+		if overlay := s.overlay(); overlay != "" {
+			w.Header().Set("Content-Type", contentTypes[".html"])
+			w.WriteHeader(http.StatusInternalServerError)
+			page := []byte("<!DOCTYPE html>\n<html>\n<body>\n</body>\n</html>\n")
+			_, _ = w.Write(injectScript(page, overlay+reloadScript))
+			return
+		}
+
+		status = http.StatusNotFound
+		if file, found = s.resolve("/404.html"); !found {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	s.serveFile(w, r, urlPath, file, status)
+}
+
+// path returns the path in the output directory for the given URL path.
+func (s *devServer) path(urlPath string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(urlPath))
+}
+
+// resolve returns the file to serve for the given URL path, which is the index.html file for directories.
+// The _headers and _redirects files are read by the host and never served.
+func (s *devServer) resolve(urlPath string) (string, bool) {
+	if urlPath == "/_headers" || urlPath == "/_redirects" {
+		return "", false
+	}
+
+	name := s.path(urlPath)
+	info, err := os.Stat(name)
+	if err == nil && info.IsDir() {
+		name = filepath.Join(name, "index.html")
+		info, err = os.Stat(name)
+	}
+	return name, err == nil && !info.IsDir()
+}
+
+// serveFile writes the given file with the given status, and the headers configured for the given URL path.
+// HTML pages are served with the reload script and the errors of the last build, if any.
+func (s *devServer) serveFile(w http.ResponseWriter, r *http.Request, urlPath string, file string, status int) {
+	for name, value := range s.headers(urlPath) {
+		w.Header()[name] = value
+	}
+	ext := filepath.Ext(file)
+	if w.Header().Get("Content-Type") == "" && contentTypes[ext] != "" {
+		w.Header().Set("Content-Type", contentTypes[ext])
+	}
+
+	if ext == ".html" {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(status)
+		_, _ = w.Write(injectScript(content, s.overlay()+reloadScript))
+		return
+	}
+
+	fh, err := os.Open(file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer fh.Close()
+
+	if status != http.StatusOK {
+		w.WriteHeader(status)
+		_, _ = io.Copy(w, fh)
+		return
+	}
+
+	info, err := fh.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.ServeContent(w, r, file, info.ModTime(), fh)
+}
+
+// setError remembers the error of the last build, to show it on every page. A nil error removes the overlay.
+func (s *devServer) setError(err error) {
+	var problems []buildProblem
+	var buildErr *site.BuildError
+	errs := []error{err}
+	if errors.As(err, &buildErr) {
+		errs = buildErr.Errors
+	}
+	for _, e := range errs {
+		if e == nil {
+			continue
+		}
+
+		problem := buildProblem{Message: e.Error()}
+		var fileErr *site.FileError
+		if errors.As(e, &fileErr) {
+			problem = buildProblem{File: fileErr.File, Line: fileErr.Line, Message: fileErr.Err.Error()}
+		}
+		problems = append(problems, problem)
+	}
+
+	s.mu.Lock()
+	s.problems = problems
+	s.mu.Unlock()
+}
+
+// overlay returns the HTML showing the errors of the last build, or an empty string if it succeeded.
+func (s *devServer) overlay() string {
+	s.mu.Lock()
+	problems := s.problems
+	s.mu.Unlock()
+	if len(problems) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	if err := overlayTemplate.Execute(&b, problems); err != nil {
+		return ""
+	}
+	return b.String()
+}
+
+// serveEvents streams reload events to a page until it is closed.
+func (s *devServer) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ch := make(chan string, 1)
+	s.mu.Lock()
+	s.clients[ch] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, ch)
+		s.mu.Unlock()
+	}()
+
+	// keep the connection open through proxies with an occasional comment
+	ping := time.NewTicker(30 * time.Second)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-ch:
+			fmt.Fprintf(w, "event: %s\ndata: \n\n", event)
+			flusher.Flush()
+		case <-ping.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+// reload tells all open pages that the given files changed.
+func (s *devServer) reload(changed []string) {
+	event := reloadEvent(changed)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch := range s.clients {
+		// a page that has not yet handled the previous event reloads anyway
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// reloadEvent returns "css" if only stylesheets changed, so pages can swap them without reloading.
+func reloadEvent(changed []string) string {
+	if len(changed) == 0 {
+		return "reload"
+	}
+	for _, file := range changed {
+		if filepath.Ext(file) != ".css" {
+			return "reload"
+		}
+	}
+	return "css"
+}
+
+// injectScript inserts script before the closing body tag of the given HTML page,
+// or at the end of the page if it has none.
+func injectScript(page []byte, script string) []byte {
+	i := bytes.LastIndex(bytes.ToLower(page), []byte("</body>"))
+	if i < 0 {
+		return append(page, script...)
+	}
+
+	out := make([]byte, 0, len(page)+len(script))
+	out = append(out, page[:i]...)
+	out = append(out, script...)
+	return append(out, page[i:]...)
+}
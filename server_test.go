@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dannyvankooten/gozer/site"
+)
+
+func TestInjectScript(t *testing.T) {
+	tests := map[string]string{
+		"<body><p>Hi</p></body></html>": "<body><p>Hi</p><script></script></body></html>",
+		"<BODY><p>Hi</p></BODY>":        "<BODY><p>Hi</p><script></script></BODY>",
+		"<p>No body</p>":                "<p>No body</p><script></script>",
+	}
+
+	for page, expected := range tests {
+		if got := string(injectScript([]byte(page), "<script></script>")); got != expected {
+			t.Errorf("Expected %q, got %q", expected, got)
+		}
+	}
+}
+
+func TestReloadEvent(t *testing.T) {
+	tests := []struct {
+		changed  []string
+		expected string
+	}{
+		{changed: []string{"public/style.css"}, expected: "css"},
+		{changed: []string{"public/style.css", "public/print.css"}, expected: "css"},
+		{changed: []string{"public/style.css", "content/index.md"}, expected: "reload"},
+		{changed: []string{"templates/default.html"}, expected: "reload"},
+		{changed: nil, expected: "reload"},
+	}
+
+	for _, tc := range tests {
+		if got := reloadEvent(tc.changed); got != tc.expected {
+			t.Errorf("reloadEvent(%v): expected %q, got %q", tc.changed, tc.expected, got)
+		}
+	}
+}
+
+func TestDevServer(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "index.html"), []byte("<body>Hello</body>"), 0655); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "style.css"), []byte("body {}"), 0655); err != nil {
+		t.Fatal(err)
+	}
+
+	server := newDevServer(dir)
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	// HTML pages get the reload script, other files are served as is
+	for path, expected := range map[string]string{"/": "<body>Hello" + reloadScript + "</body>", "/style.css": "body {}"} {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Body.String() != expected {
+			t.Errorf("%s: expected %q, got %q", path, expected, rec.Body.String())
+		}
+	}
+
+	res, err := http.Get(ts.URL + reloadPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Expected event stream, got %q", ct)
+	}
+
+	// wait for the client to be registered before sending an event
+	for {
+		server.mu.Lock()
+		n := len(server.clients)
+		server.mu.Unlock()
+		if n > 0 {
+			break
+		}
+	}
+	server.reload([]string{"public/style.css"})
+
+	line, err := bufio.NewReader(res.Body).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "event: css\n" {
+		t.Errorf("Expected css event, got %q", line)
+	}
+}
+
+func TestDevServerErrorOverlay(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "index.html"), []byte("<body>Hello</body>"), 0655); err != nil {
+		t.Fatal(err)
+	}
+
+	server := newDevServer(dir)
+	server.setError(&site.BuildError{Errors: []error{
+		&site.FileError{File: "content/index.md", Line: 3, Err: errors.New("toml: expected <b>value</b>")},
+		errors.New("error creating sitemap"),
+	}})
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{path: "/", status: http.StatusOK},
+		{path: "/missing/", status: http.StatusInternalServerError},
+	}
+	for _, tc := range tests {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest("GET", tc.path, nil))
+		if rec.Code != tc.status {
+			t.Errorf("%s: expected status %d, got %d", tc.path, tc.status, rec.Code)
+		}
+		for _, expected := range []string{"Build failed with 2 error(s)", "content/index.md:3", "toml: expected &lt;b&gt;value&lt;/b&gt;", "error creating sitemap"} {
+			if !strings.Contains(rec.Body.String(), expected) {
+				t.Errorf("%s: expected overlay to contain %q, got %q", tc.path, expected, rec.Body.String())
+			}
+		}
+	}
+
+	// a successful build removes the overlay
+	server.setError(nil)
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if strings.Contains(rec.Body.String(), "gozer-errors") {
+		t.Errorf("Expected overlay to be removed, got %q", rec.Body.String())
+	}
+}
+
+func TestDevServerErrorOverlayAfterRebuild(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"config.toml":            "url = \"http://localhost:8080\"\ntitle = \"Overlay\"\n",
+		"templates/default.html": "<body>{{ .Content }}</body>",
+		"content/a.md":           "+++\ntitle = \"A\n+++\n\nBroken.\n",
+		"content/b.md":           "+++\ntitle = \"B\"\n+++\n\nB.\n",
+		"public/robots.txt":      "User-agent: *\n",
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0655); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, err := site.Build(context.Background(), site.Options{RootDir: dir, KeepGoing: true})
+	if result == nil {
+		t.Fatalf("Expected output to be written, got %v", err)
+	}
+	server := newDevServer(result.OutputDir)
+	server.setError(err)
+
+	// editing another file keeps the overlay, as a.md is still broken
+	b := filepath.Join(dir, "content", "b.md")
+	if err := os.WriteFile(b, []byte("+++\ntitle = \"B\"\n+++\n\nEdited.\n"), 0655); err != nil {
+		t.Fatal(err)
+	}
+	result, err = result.Site.Rebuild(context.Background(), []string{b})
+	if result == nil {
+		t.Fatalf("Expected output to be written, got %v", err)
+	}
+	server.setError(err)
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest("GET", "/b/", nil))
+	for _, expected := range []string{"Edited.", "gozer-errors", "a.md:2"} {
+		if !strings.Contains(rec.Body.String(), expected) {
+			t.Errorf("Expected page to contain %q, got %q", expected, rec.Body.String())
+		}
+	}
+}
+
+func TestDevServerStaticHost(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"index.html":           "<body>Home</body>",
+		"404.html":             "<body>Not found</body>",
+		"feed.xml":             "<rss></rss>",
+		"sitemap.xsl":          "<xsl></xsl>",
+		"about/index.html":     "<body>About</body>",
+		"images/logo.txt":      "logo",
+		"blog/post/index.html": "<body>Post</body>",
+		"_headers":             "/feed.xml\n  X-Robots-Tag: noindex\n  Content-Type: application/rss+xml\n\n# all pages\n/*\n  X-Frame-Options: DENY\n",
+		"_redirects":           "# comment\n/old-about/ /about/\n/docs/* /blog/:splat 302\n/rewrite /about/ 200\n/posts/:slug /blog/:slug/\n/about/ /index.html 301!\n/gone/* /about/ 404\n",
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0655); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		path        string
+		status      int
+		body        string
+		location    string
+		contentType string
+		headers     map[string]string
+	}{
+		{path: "/", status: 200, body: "Home", contentType: "text/html; charset=utf-8", headers: map[string]string{"X-Frame-Options": "DENY"}},
+		{path: "/missing/", status: 404, body: "Not found"},
+		{path: "/images/", status: 404, body: "Not found"},
+		{path: "/images", status: 301, location: "/images/"},
+		{path: "/images/logo.txt", status: 200, body: "logo"},
+		{path: "/feed.xml", status: 200, contentType: "application/rss+xml", headers: map[string]string{"X-Robots-Tag": "noindex"}},
+		{path: "/sitemap.xsl", status: 200, contentType: "text/xsl; charset=utf-8"},
+		{path: "/old-about/", status: 301, location: "/about/"},
+		{path: "/old-about", status: 301, location: "/about/"},
+		{path: "/docs/post/", status: 302, location: "/blog/post"},
+		{path: "/posts/post", status: 301, location: "/blog/post/"},
+		{path: "/rewrite", status: 200, body: "About"},
+		{path: "/about/", status: 301, location: "/index.html"},
+		{path: "/gone/post/", status: 404, body: "About"},
+		{path: "/_redirects", status: 404, body: "Not found"},
+		{path: "/_headers", status: 404, body: "Not found"},
+	}
+
+	server := newDevServer(dir)
+	for _, tc := range tests {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest("GET", tc.path, nil))
+
+		if rec.Code != tc.status {
+			t.Errorf("%s: expected status %d, got %d", tc.path, tc.status, rec.Code)
+		}
+		if !strings.Contains(rec.Body.String(), tc.body) {
+			t.Errorf("%s: expected body to contain %q, got %q", tc.path, tc.body, rec.Body.String())
+		}
+		if location := rec.Header().Get("Location"); location != tc.location {
+			t.Errorf("%s: expected location %q, got %q", tc.path, tc.location, location)
+		}
+		if tc.contentType != "" && rec.Header().Get("Content-Type") != tc.contentType {
+			t.Errorf("%s: expected content type %q, got %q", tc.path, tc.contentType, rec.Header().Get("Content-Type"))
+		}
+		for name, value := range tc.headers {
+			if got := rec.Header().Get(name); got != value {
+				t.Errorf("%s: expected header %s to be %q, got %q", tc.path, name, value, got)
+			}
+		}
+	}
+}
+
+func TestDevServerNotFoundPage(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"config.toml":            "url = \"http://localhost:8080\"\ntitle = \"Not found\"\n",
+		"templates/default.html": "<body>{{ .Content }}</body>",
+		"content/index.md":       "+++\ntitle = \"Home\"\n+++\n\nHome.\n",
+		"content/404.md":         "+++\ntitle = \"Not found\"\n+++\n\nThis page does not exist.\n",
+		"public/robots.txt":      "User-agent: *\n",
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0655); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, err := site.Build(context.Background(), site.Options{RootDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	newDevServer(result.OutputDir).ServeHTTP(rec, httptest.NewRequest("GET", "/missing/", nil))
+	if rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), "This page does not exist.") {
+		t.Errorf("Expected 404 page with status 404, got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestMatchPath(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		params  map[string]string
+		ok      bool
+	}{
+		{pattern: "/about/", path: "/about", params: map[string]string{}, ok: true},
+		{pattern: "/about", path: "/about/team/", ok: false},
+		{pattern: "/blog/:slug", path: "/blog/hello/", params: map[string]string{"slug": "hello"}, ok: true},
+		{pattern: "/blog/*", path: "/blog/2023/hello/", params: map[string]string{"splat": "2023/hello"}, ok: true},
+		{pattern: "/*", path: "/", params: map[string]string{"splat": ""}, ok: true},
+		{pattern: "/blog/:slug", path: "/blog/", ok: false},
+	}
+
+	for _, tc := range tests {
+		params, ok := matchPath(tc.pattern, tc.path)
+		if ok != tc.ok || (ok && fmt.Sprint(params) != fmt.Sprint(tc.params)) {
+			t.Errorf("matchPath(%q, %q): expected %v %v, got %v %v", tc.pattern, tc.path, tc.params, tc.ok, params, ok)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package site
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Page written for every alias, redirecting to the page it is an alias of
+var aliasTemplate = template.Must(template.New("alias").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<title>{{ .Permalink }}</title>
+<link rel="canonical" href="{{ .Permalink }}">
+<meta name="robots" content="noindex">
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="0; url={{ .Permalink }}">
+</head>
+</html>
+`))
+
+// alias is an additional URL path for a page, redirecting to that page.
+type alias struct {
+	// URL path of this alias, relative to site URL
+	UrlPath string
+
+	// The page this alias redirects to
+	Page *Page
+}
+
+// cleanAlias turns the given alias into a URL path relative to the site URL.
+// Aliases ending in .html are kept as-is, all others get a trailing slash.
+func cleanAlias(a string) string {
+	a = strings.TrimPrefix(path.Clean("/"+a), "/")
+	if a == "" || path.Ext(a) == ".html" {
+		return a
+	}
+	return a + "/"
+}
+
+// collectAliases returns the aliases of the given pages.
+// Aliases that collide with each other or with a page, including the later pages of a paginated page,
+// are left out and returned as errors.
+func (s *Site) collectAliases(pages []Page) ([]alias, error) {
+	urlPaths := make(map[string]*Page, len(pages))
+	for i := range pages {
+		urlPaths[pages[i].UrlPath] = &pages[i]
+		if pages[i].Paginate > 0 {
+			for _, paginator := range s.paginate(&pages[i]) {
+				urlPaths[paginator.UrlPath] = &pages[i]
+			}
+		}
+	}
+
+	var errs []error
+	var aliases []alias
+	seen := make(map[string]*Page)
+	for i := range pages {
+		p := &pages[i]
+		for _, a := range p.Aliases {
+			urlPath := cleanAlias(a)
+			if other, ok := urlPaths[urlPath]; ok {
+				errs = append(errs, fmt.Errorf("%s: alias /%s collides with page %s", p.Filepath, urlPath, pageName(other)))
+				continue
+			}
+			if other, ok := seen[urlPath]; ok {
+				errs = append(errs, fmt.Errorf("%s: alias /%s collides with alias of %s", p.Filepath, urlPath, other.Filepath))
+				continue
+			}
+
+			seen[urlPath] = p
+			aliases = append(aliases, alias{UrlPath: urlPath, Page: p})
+		}
+	}
+
+	return aliases, errors.Join(errs...)
+}
+
+// createAliases writes a redirect page for every alias of the given pages.
+// If enabled in the configuration, it also writes all aliases to a _redirects file,
+// after the rules in public/_redirects. It must run after copying public/, so it replaces the copied file.
+func (s *Site) createAliases(pages []Page) error {
+	aliases, err := s.collectAliases(pages)
+	errs := []error{err}
+
+	s.deps.aliases = nil
+	for _, a := range aliases {
+		name := a.UrlPath
+		if path.Ext(name) != ".html" {
+			name = filepath.Join(name, "index.html")
+		}
+
+		s.deps.aliases = append(s.deps.aliases, name)
+		if err := s.out.executeTemplate(aliasTemplate, name, a.Page); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if s.RedirectsFile {
+		// hosts apply the first rule matching a path, so the rules written by hand come first
+		rules, err := os.ReadFile(filepath.Join(s.RootDir, "public", "_redirects"))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, fmt.Errorf("error reading public/_redirects: %w", err))
+		}
+
+		var b strings.Builder
+		b.Write(rules)
+		if len(rules) > 0 && !bytes.HasSuffix(rules, []byte("\n")) {
+			b.WriteByte('\n')
+		}
+		for _, a := range aliases {
+			fmt.Fprintf(&b, "/%s /%s 301\n", a.UrlPath, a.Page.UrlPath)
+		}
+		if b.Len() > 0 {
+			s.deps.aliases = append(s.deps.aliases, "_redirects")
+			if err := s.out.writeFile("_redirects", []byte(b.String())); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,387 @@
+package site
+
+import (
+	"html/template"
+	"maps"
+	"slices"
+	"sync"
+	"text/template/parse"
+	"time"
+)
+
+// Template variables and page fields that give a template access to other pages.
+// A page rendered with a template that uses any of these depends on every page in the site.
+var listFields = map[string]bool{
+	"Pages":      true,
+	"Posts":      true,
+	"Paginator":  true,
+	"Taxonomy":   true,
+	"Term":       true,
+	"Taxonomies": true,
+	"Parent":     true,
+	"Children":   true,
+}
+
+// Template variables that give a template access to the posts next to the current one.
+// A page rendered with a template that uses any of these depends on its neighbouring posts.
+var neighbourFields = map[string]bool{
+	"Prev":          true,
+	"Next":          true,
+	"PrevInSection": true,
+	"NextInSection": true,
+}
+
+// Page fields that change whenever the content of a page is edited, unlike its front matter and summary.
+// A template showing these for the pages it lists is written again whenever any page changes.
+var contentFields = map[string]bool{
+	"Content":         true,
+	"TableOfContents": true,
+	"WordCount":       true,
+	"ReadingTime":     true,
+	"DateModified":    true,
+}
+
+// templateDeps describes what a template, including the templates it calls, depends on.
+type templateDeps struct {
+	// Source of the template, to tell whether it changed
+	text string
+
+	// Names of the templates it calls, directly or indirectly
+	calls map[string]bool
+
+	// Whether it lists other pages
+	list bool
+
+	// Whether it uses the neighbouring posts of the page
+	neighbours bool
+
+	// Whether it shows the content of the pages it lists, rather than just their front matter and summary
+	listContent bool
+
+	// Whether it uses the content of a page at all, for templates called with a listed page
+	content bool
+
+	// Names of the templates it calls with a listed page
+	listCalls map[string]bool
+
+	// Variables holding other pages, such as $posts in {{ $posts := .Posts }}, only set while walking the template
+	listVars map[string]bool
+}
+
+// deps is the dependency graph from source files and templates to output files,
+// used to decide which files to write again when a source file changes.
+type deps struct {
+	mu sync.Mutex
+
+	// Output files written for every page, keyed by page name
+	outputs map[string][]string
+
+	// Output files written for aliases
+	aliases []string
+
+	// Output files copied from the public/ directory
+	static map[string]bool
+
+	// Dependencies of every template, keyed by template name
+	templates map[string]templateDeps
+
+	// Shortcode templates used in every page source, keyed by source file
+	shortcodes map[string]map[string]bool
+
+	// Time at which every page source with a publish or expiry date in the future changes whether it is published
+	scheduled map[string]time.Time
+}
+
+// setOutputs records the output files written for the page with the given name.
+func (d *deps) setOutputs(name string, files []string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.outputs == nil {
+		d.outputs = make(map[string][]string)
+	}
+	d.outputs[name] = files
+}
+
+// setShortcodes records the shortcode templates used in the given page source file.
+func (d *deps) setShortcodes(file string, names map[string]bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.shortcodes == nil {
+		d.shortcodes = make(map[string]map[string]bool)
+	}
+	d.shortcodes[file] = names
+}
+
+// setScheduled records the time at which the given page source file changes whether it is published.
+// A zero time means it does not change.
+func (d *deps) setScheduled(file string, t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if t.IsZero() {
+		delete(d.scheduled, file)
+		return
+	}
+	if d.scheduled == nil {
+		d.scheduled = make(map[string]time.Time)
+	}
+	d.scheduled[file] = t
+}
+
+// addStatic records the given output files as copied from the public/ directory.
+func (d *deps) addStatic(names []string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.static == nil {
+		d.static = make(map[string]bool)
+	}
+	for _, name := range names {
+		d.static[name] = true
+	}
+}
+
+// removeStatic forgets the output files copied from the given file or directory, relative to the public/ directory,
+// and returns them.
+func (d *deps) removeStatic(rel string) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var names []string
+	for name := range d.static {
+		if isInside(name, rel) {
+			names = append(names, name)
+			delete(d.static, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// scheduledBefore returns the page source files that changed whether they are published before the given time.
+func (d *deps) scheduledBefore(now time.Time) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var files []string
+	for file, t := range d.scheduled {
+		if !t.After(now) {
+			files = append(files, file)
+		}
+	}
+	slices.Sort(files)
+	return files
+}
+
+// usesShortcodes reports whether the given page source file uses any of the given templates as a shortcode,
+// directly or through the templates called by its shortcodes.
+func (d *deps) usesShortcodes(file string, templates map[string]bool) bool {
+	for name := range d.shortcodes[file] {
+		if d.usesTemplate(name, templates) {
+			return true
+		}
+	}
+	return false
+}
+
+// changedTemplates returns the names of the templates that changed in next since the dependencies were recorded.
+// It returns false if templates were added or removed.
+func (d *deps) changedTemplates(next map[string]templateDeps) (map[string]bool, bool) {
+	if len(next) != len(d.templates) {
+		return nil, false
+	}
+
+	changed := make(map[string]bool)
+	for name, td := range next {
+		old, ok := d.templates[name]
+		if !ok {
+			return nil, false
+		}
+		if old.text != td.text {
+			changed[name] = true
+		}
+	}
+	return changed, true
+}
+
+// usesTemplate reports whether pages rendered with the template with the given name
+// depend on any of the given templates.
+func (d *deps) usesTemplate(name string, templates map[string]bool) bool {
+	if templates[name] {
+		return true
+	}
+	for called := range d.templates[name].calls {
+		if templates[called] {
+			return true
+		}
+	}
+	return false
+}
+
+// analyzeTemplates returns the dependencies of every template in t.
+// It must be called before any of the templates are executed, as executing a template changes its parse tree.
+func analyzeTemplates(t *template.Template) map[string]templateDeps {
+	// direct dependencies of every template
+	direct := make(map[string]*templateDeps)
+	for _, tmpl := range t.Templates() {
+		if tmpl.Tree == nil || tmpl.Tree.Root == nil {
+			continue
+		}
+
+		td := &templateDeps{
+			text:      tmpl.Tree.Root.String(),
+			calls:     make(map[string]bool),
+			listCalls: make(map[string]bool),
+			listVars:  make(map[string]bool),
+		}
+		walkTemplate(tmpl.Tree.Root, td, false)
+		direct[tmpl.Name()] = td
+	}
+
+	// reachable returns the templates called by the template with the given name, directly or indirectly
+	reachable := func(name string) map[string]bool {
+		calls := make(map[string]bool)
+		queue := []string{name}
+		for len(queue) > 0 {
+			current, ok := direct[queue[0]]
+			queue = queue[1:]
+			if !ok {
+				continue
+			}
+			for called := range current.calls {
+				if !calls[called] {
+					calls[called] = true
+					queue = append(queue, called)
+				}
+			}
+		}
+		return calls
+	}
+
+	// usesContent reports whether the template with the given name, or any template it calls, uses the content of a page
+	usesContent := func(name string) bool {
+		for called := range reachable(name) {
+			if td, ok := direct[called]; ok && td.content {
+				return true
+			}
+		}
+		td, ok := direct[name]
+		return ok && td.content
+	}
+
+	// follow template calls, so every template inherits the dependencies of the templates it calls
+	all := make(map[string]templateDeps, len(direct))
+	for name, td := range direct {
+		result := templateDeps{
+			text:  td.text,
+			calls: reachable(name),
+		}
+
+		for _, current := range append(slices.Collect(maps.Keys(result.calls)), name) {
+			current, ok := direct[current]
+			if !ok {
+				continue
+			}
+
+			result.list = result.list || current.list
+			result.neighbours = result.neighbours || current.neighbours
+			result.listContent = result.listContent || current.listContent
+			result.content = result.content || current.content
+
+			// a template called with a listed page shows its content
+			for called := range current.listCalls {
+				result.listContent = result.listContent || usesContent(called)
+			}
+		}
+		all[name] = result
+	}
+
+	return all
+}
+
+// walkTemplate records the templates called and the fields used by the given node and its children.
+// inList is set for nodes that are executed with a listed page, such as the body of a range over .Posts.
+func walkTemplate(node parse.Node, td *templateDeps, inList bool) {
+	idents := func(idents []string, variable bool) {
+		if variable && td.listVars[idents[0]] {
+			td.list = true
+		}
+		for _, ident := range idents {
+			td.list = td.list || listFields[ident]
+			td.neighbours = td.neighbours || neighbourFields[ident]
+			if contentFields[ident] {
+				// a variable may hold a listed page as well
+				td.content = true
+				td.listContent = td.listContent || inList || (variable && idents[0] != "$")
+			}
+		}
+	}
+
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			walkTemplate(child, td, inList)
+		}
+	case *parse.ActionNode:
+		walkTemplate(n.Pipe, td, inList)
+	case *parse.IfNode:
+		walkBranch(&n.BranchNode, td, inList, inList)
+	case *parse.RangeNode:
+		walkBranch(&n.BranchNode, td, inList, inList || listsPages(n.Pipe, td.listVars))
+	case *parse.WithNode:
+		walkBranch(&n.BranchNode, td, inList, inList || listsPages(n.Pipe, td.listVars))
+	case *parse.TemplateNode:
+		td.calls[n.Name] = true
+		if inList || listsPages(n.Pipe, td.listVars) {
+			td.listCalls[n.Name] = true
+		}
+		walkTemplate(n.Pipe, td, inList)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			walkTemplate(cmd, td, inList)
+		}
+
+		// a variable assigned other pages lists them wherever it is used
+		if len(n.Decl) > 0 && listsPages(n, td.listVars) {
+			for _, v := range n.Decl {
+				td.listVars[v.Ident[0]] = true
+			}
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			walkTemplate(arg, td, inList)
+		}
+	case *parse.ChainNode:
+		walkTemplate(n.Node, td, inList)
+		idents(n.Field, true)
+	case *parse.FieldNode:
+		idents(n.Ident, false)
+	case *parse.VariableNode:
+		idents(n.Ident, true)
+	case *parse.StringNode:
+		// e.g. {{ index . "Posts" }}
+		idents([]string{n.Text}, false)
+	}
+}
+
+// walkBranch walks the given if, range or with node. Its body is executed with a listed page if listed is set.
+func walkBranch(n *parse.BranchNode, td *templateDeps, inList bool, listed bool) {
+	walkTemplate(n.Pipe, td, inList)
+	walkTemplate(n.List, td, listed)
+	walkTemplate(n.ElseList, td, listed)
+}
+
+// listsPages reports whether the given pipeline uses any of the fields, or the given variables,
+// that give access to other pages.
+func listsPages(pipe *parse.PipeNode, listVars map[string]bool) bool {
+	if pipe == nil {
+		return false
+	}
+	td := &templateDeps{calls: make(map[string]bool), listCalls: make(map[string]bool), listVars: listVars}
+	for _, cmd := range pipe.Cmds {
+		walkTemplate(cmd, td, false)
+	}
+	return td.list
+}
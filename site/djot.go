@@ -0,0 +1,332 @@
+package site
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"git.sr.ht/~ser/godjot/v2/djot_html"
+	"git.sr.ht/~ser/godjot/v2/djot_parser"
+	"git.sr.ht/~ser/godjot/v2/djot_tokenizer"
+	"git.sr.ht/~ser/godjot/v2/tokenizer"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+)
+
+// DjotConfig configures the djot converter, from the [markup.djot] configuration table.
+type DjotConfig struct {
+	// Smart quotes, dashes and ellipses. Defaults to true.
+	SmartPunctuation bool `toml:"smart_punctuation"`
+
+	// Attributes on blocks and inline elements, e.g. "{#id .class}". Defaults to true.
+	// Code blocks take their highlighting options from attributes too.
+	Attributes bool `toml:"attributes"`
+
+	// Generate an id attribute for every heading from its text. Defaults to true.
+	AutoHeadingIDs bool `toml:"auto_heading_ids"`
+}
+
+type djotNode = djot_parser.TreeNode[djot_parser.DjotNode]
+
+// matches the first line of a footnote definition, e.g. "[^note]: text"
+var footnoteDefinition = regexp.MustCompile(`^\[\^([^\]\s]+)\]:`)
+
+// ASCII forms of the punctuation djot makes smart
+var plainPunctuation = strings.NewReplacer("“", `"`, "”", `"`, "‘", "'", "’", "'", "…", "...", "–", "--", "—", "---")
+
+// ConvertDjot converts djot to HTML, with an id on every heading.
+func ConvertDjot(content []byte) string {
+	html, _ := convertDjot(content, defaultMarkup())
+	return html
+}
+
+// convertDjot converts djot to HTML with the given markup configuration.
+func convertDjot(content []byte, markup MarkupConfig) (string, error) {
+	config := markup.Djot
+	body, notes := splitFootnotes(content)
+	if !config.Attributes {
+		body = stripAttributes(body)
+		for label, note := range notes {
+			notes[label] = stripAttributes(note)
+		}
+	}
+
+	nodes := djot_parser.BuildDjotAst(body)
+	nodes = appendFootnotes(nodes, body, notes)
+	setHeadingIDs(nodes, parser.NewContext().IDs(), config.AutoHeadingIDs)
+	if !config.SmartPunctuation {
+		walkDjot(nodes, func(n *djotNode) {
+			if n.Type == djot_parser.TextNode && isSmartPunctuation(n.Text) {
+				n.Text = []byte(plainPunctuation.Replace(string(n.Text)))
+			}
+		})
+	}
+
+	registry := map[djot_parser.DjotNode]djot_parser.Conversion[*djot_html.HtmlWriter]{}
+	var err error
+	if markup.Highlight.enabled {
+		registry[djot_parser.CodeNode] = highlightCodeNode(markup.Highlight, &err)
+	}
+
+	converter := djot_html.New(djot_html.DefaultConversionRegistry, registry)
+	html := converter.ConvertDjot(&djot_html.HtmlWriter{}, nodes...).String()
+	return html, err
+}
+
+// highlightCodeNode returns a converter for code blocks that highlights them with the options in their attributes,
+// e.g. {hl_lines="2 4-5" linenos=true}. The first error highlighting a code block is stored in err.
+func highlightCodeNode(config HighlightConfig, err *error) djot_parser.Conversion[*djot_html.HtmlWriter] {
+	return func(state djot_parser.ConversionState[*djot_html.HtmlWriter], next func(c djot_parser.Children)) {
+		var lang string
+		for _, class := range strings.Fields(state.Node.Attributes.Get("class")) {
+			if l, ok := strings.CutPrefix(class, "language-"); ok {
+				lang = l
+			}
+		}
+
+		var code strings.Builder
+		for _, child := range state.Node.Children {
+			code.Write(child.Text)
+		}
+
+		var out strings.Builder
+		if e := config.highlight(&out, code.String(), config.codeOptions(lang, state.Node.Attributes.GoMap())); e != nil && *err == nil {
+			*err = e
+		}
+		state.Writer.WriteString(out.String()).WriteString("\n")
+	}
+}
+
+// splitFootnotes removes the footnote definitions from the given djot document and returns them by label.
+// godjot does not end a footnote definition at the next unindented line, so every definition
+// would take the rest of the document with it. A definition ends at the first line that is
+// neither blank nor indented.
+func splitFootnotes(content []byte) ([]byte, map[string][]byte) {
+	var body []byte
+	notes := make(map[string][]byte)
+	var label, fence string
+	for _, line := range bytes.SplitAfter(content, []byte("\n")) {
+		trimmed := bytes.TrimSpace(line)
+		if label != "" {
+			if len(trimmed) == 0 || line[0] == ' ' || line[0] == '\t' {
+				notes[label] = append(notes[label], line...)
+				continue
+			}
+			label = ""
+		}
+
+		// footnotes can not be defined in code blocks
+		if backticks := bytes.TrimLeft(trimmed, "`"); len(trimmed)-len(backticks) >= 3 {
+			marker := string(trimmed[:len(trimmed)-len(backticks)])
+			switch {
+			case fence == "":
+				fence = marker
+			case len(backticks) == 0 && len(marker) >= len(fence):
+				fence = ""
+			}
+		}
+
+		if m := footnoteDefinition.FindSubmatch(line); m != nil && fence == "" {
+			label = string(m[1])
+			notes[label] = append([]byte(nil), line...)
+			continue
+		}
+		body = append(body, line...)
+	}
+
+	return body, notes
+}
+
+// appendFootnotes numbers the footnote references in the given nodes in the order they appear,
+// and appends the list of footnotes they refer to, as godjot would.
+func appendFootnotes(nodes []djotNode, body []byte, notes map[string][]byte) []djotNode {
+	numbers := make(map[string]int)
+	var labels []string
+	numberReferences := func(nodes []djotNode, source []byte) {
+		refs := footnoteReferences(source)
+		i := 0
+		walkDjot(nodes, func(n *djotNode) {
+			if n.Type != djot_parser.LinkNode || n.Attributes.Get(djot_parser.RoleKey) != "doc-noteref" || i >= len(refs) {
+				return
+			}
+
+			label := refs[i]
+			i++
+			if numbers[label] == 0 {
+				labels = append(labels, label)
+				numbers[label] = len(labels)
+			}
+			number := numbers[label]
+			n.Attributes = tokenizer.NewAttributes(
+				tokenizer.AttributeEntry{Key: djot_parser.IdKey, Value: fmt.Sprintf("fnref%d", number)},
+				tokenizer.AttributeEntry{Key: djot_parser.LinkHrefKey, Value: fmt.Sprintf("#fn%d", number)},
+				tokenizer.AttributeEntry{Key: djot_parser.RoleKey, Value: "doc-noteref"},
+			)
+			n.Children = []djotNode{{Type: djot_parser.SuperscriptNode, Children: []djotNode{{Type: djot_parser.TextNode, Text: []byte(fmt.Sprint(number))}}}}
+		})
+	}
+	nodes = removeFootnotes(nodes)
+	numberReferences(nodes, body)
+
+	// footnotes may refer to other footnotes, which are numbered after them
+	var footnotes []djotNode
+	for i := 0; i < len(labels); i++ {
+		number := i + 1
+		var children []djotNode
+		if note, ok := notes[labels[i]]; ok {
+			noteNodes := djot_parser.BuildDjotAst(note)
+			walkDjot(noteNodes, func(n *djotNode) {
+				if n.Type == djot_parser.FootnoteDefNode && children == nil {
+					children = n.Children
+				}
+			})
+			numberReferences(children, note)
+		}
+
+		backlink := djotNode{
+			Type:     djot_parser.LinkNode,
+			Children: []djotNode{{Type: djot_parser.TextNode, Text: []byte("↩︎︎")}},
+			Attributes: tokenizer.NewAttributes(
+				tokenizer.AttributeEntry{Key: djot_parser.LinkHrefKey, Value: fmt.Sprintf("#fnref%d", number)},
+				tokenizer.AttributeEntry{Key: djot_parser.RoleKey, Value: "doc-backlink"},
+			),
+		}
+		children = removeBacklinks(children)
+		if len(children) > 0 && children[len(children)-1].Type == djot_parser.ParagraphNode {
+			children[len(children)-1].Children = append(children[len(children)-1].Children, backlink)
+		} else {
+			children = append(children, djotNode{Type: djot_parser.ParagraphNode, Children: []djotNode{backlink}})
+		}
+
+		footnotes = append(footnotes, djotNode{
+			Type:       djot_parser.ListItemNode,
+			Children:   []djotNode{{Type: djot_parser.FootnoteDefNode, Children: children}},
+			Attributes: tokenizer.NewAttributes(tokenizer.AttributeEntry{Key: djot_parser.IdKey, Value: fmt.Sprintf("fn%d", number)}),
+		})
+	}
+
+	if len(footnotes) == 0 {
+		return nodes
+	}
+	return append(nodes, djotNode{
+		Type:       djot_parser.SectionNode,
+		Attributes: tokenizer.NewAttributes(tokenizer.AttributeEntry{Key: djot_parser.RoleKey, Value: "doc-endnotes"}),
+		Children: []djotNode{
+			{Type: djot_parser.ThematicBreakNode},
+			{Type: djot_parser.OrderedListNode, Children: footnotes},
+		},
+	})
+}
+
+// footnoteReferences returns the labels of the footnote references in the given djot document, in order.
+func footnoteReferences(source []byte) []string {
+	var labels []string
+	tokens := djot_tokenizer.BuildDjotTokens(source)
+	for i, token := range tokens {
+		if token.Type == djot_tokenizer.FootnoteReferenceInline && token.JumpToPair > 0 {
+			labels = append(labels, string(source[token.End:tokens[i+token.JumpToPair].Start]))
+		}
+	}
+	return labels
+}
+
+// removeFootnotes removes the list of footnotes godjot appends to a document.
+func removeFootnotes(nodes []djotNode) []djotNode {
+	if n := len(nodes); n > 0 && nodes[n-1].Type == djot_parser.SectionNode && nodes[n-1].Attributes.Get(djot_parser.RoleKey) == "doc-endnotes" {
+		return nodes[:n-1]
+	}
+	return nodes
+}
+
+// removeBacklinks removes the link back to the reference godjot adds to the last paragraph of a footnote.
+func removeBacklinks(nodes []djotNode) []djotNode {
+	if n := len(nodes); n > 0 && nodes[n-1].Type == djot_parser.ParagraphNode {
+		p := &nodes[n-1]
+		if m := len(p.Children); m > 0 && p.Children[m-1].Attributes.Get(djot_parser.RoleKey) == "doc-backlink" {
+			p.Children = p.Children[:m-1]
+		}
+		if len(p.Children) == 0 {
+			return nodes[:n-1]
+		}
+	}
+	return nodes
+}
+
+// stripAttributes removes all attributes, e.g. "{#id .class}", from the given djot document.
+func stripAttributes(source []byte) []byte {
+	var out []byte
+	start := 0
+	for _, token := range djot_tokenizer.BuildDjotTokens(source) {
+		if token.Type == djot_tokenizer.Attribute {
+			out = append(out, source[start:token.Start]...)
+			start = token.End
+		}
+	}
+	return append(out, source[start:]...)
+}
+
+// setHeadingIDs moves the id of every section to its heading, so headings have an id like in Markdown.
+// If generate is set, headings without an id get one generated the same way as for Markdown headings,
+// which is unique on the page. Ids set in attributes are kept, and links to sections are updated.
+func setHeadingIDs(nodes []djotNode, ids parser.IDs, generate bool) {
+	sectionIDs := make(map[string]string)
+	walkDjot(nodes, func(n *djotNode) {
+		if n.Type != djot_parser.SectionNode || n.Attributes.Get(djot_parser.RoleKey) == "doc-endnotes" {
+			return
+		}
+
+		sectionID := n.Attributes.Get(djot_parser.IdKey)
+		n.Attributes = tokenizer.Attributes{}
+		for i := range n.Children {
+			heading := &n.Children[i]
+			if heading.Type != djot_parser.HeadingNode {
+				continue
+			}
+
+			id, ok := heading.Attributes.TryGet(djot_parser.IdKey)
+			switch {
+			case ok:
+				ids.Put([]byte(id))
+			case generate:
+				id = string(ids.Generate(djotText(heading.Children), ast.KindHeading))
+				heading.Attributes.Set(djot_parser.IdKey, id)
+			}
+			if _, seen := sectionIDs[sectionID]; !seen {
+				sectionIDs[sectionID] = id
+			}
+			break
+		}
+	})
+
+	// headings can be linked to by their text, e.g. [Getting started][]
+	walkDjot(nodes, func(n *djotNode) {
+		href := n.Attributes.Get(djot_parser.LinkHrefKey)
+		if id, ok := sectionIDs[strings.TrimPrefix(href, "#")]; n.Type == djot_parser.LinkNode && ok && strings.HasPrefix(href, "#") && id != "" {
+			n.Attributes.Set(djot_parser.LinkHrefKey, "#"+id)
+		}
+	})
+}
+
+// walkDjot calls fn for every node in the given tree, parents before their children.
+func walkDjot(nodes []djotNode, fn func(n *djotNode)) {
+	for i := range nodes {
+		fn(&nodes[i])
+		walkDjot(nodes[i].Children, fn)
+	}
+}
+
+// djotText returns the text of the given nodes, without any markup.
+func djotText(nodes []djotNode) []byte {
+	var text []byte
+	for _, n := range nodes {
+		text = append(text, n.Text...)
+		text = append(text, djotText(n.Children)...)
+	}
+	return text
+}
+
+// isSmartPunctuation reports whether the given text consists only of punctuation djot made smart.
+func isSmartPunctuation(text []byte) bool {
+	return len(text) > 0 && strings.Trim(string(text), "“”‘’…–—") == ""
+}
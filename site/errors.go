@@ -0,0 +1,197 @@
+package site
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// BuildError is returned by Build when one or more pages, templates or generated files could not be written.
+type BuildError struct {
+	Errors []error
+}
+
+func (e *BuildError) Error() string {
+	var b strings.Builder
+	if len(e.Errors) == 1 {
+		b.WriteString("build failed with 1 error:")
+	} else {
+		fmt.Fprintf(&b, "build failed with %d errors:", len(e.Errors))
+	}
+	for _, err := range e.Errors {
+		b.WriteString("\n\t")
+		b.WriteString(err.Error())
+	}
+	return b.String()
+}
+
+func (e *BuildError) Unwrap() []error {
+	return e.Errors
+}
+
+// FileError is an error in a single source file, with the line it occurred at if known.
+type FileError struct {
+	// Path to the file, or the URL path of a generated page
+	File string
+
+	// Line in the file, starting at 1. Zero if unknown.
+	Line int
+
+	Err error
+}
+
+func (e *FileError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Err)
+	}
+	return fmt.Sprintf("%s: %s", e.File, e.Err)
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// fileError returns err as an error in the given file, unless it already is a *FileError.
+func fileError(file string, err error) error {
+	var fe *FileError
+	if errors.As(err, &fe) {
+		return err
+	}
+	return &FileError{File: file, Err: err}
+}
+
+// problems collects the errors and warnings of a build, which may happen concurrently.
+type problems struct {
+	mu       sync.Mutex
+	errors   []error
+	warnings []error
+
+	// Errors reading source files, keyed by file, and writing pages, keyed by page name.
+	// Rebuild keeps them until the file is read or the page is written again.
+	files map[string][]error
+	pages map[string][]error
+
+	// Errors of the steps creating files for the whole site, such as aliases and the sitemap, keyed by step.
+	// Rebuild keeps them until the step runs again.
+	steps map[string][]error
+
+	// Report warnings as errors
+	strict bool
+}
+
+// error records an error that fails the build.
+// Errors joined with errors.Join are recorded separately.
+func (ps *problems) error(err error) {
+	if err == nil {
+		return
+	}
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		ps.errors = append(ps.errors, joined.Unwrap()...)
+	} else {
+		ps.errors = append(ps.errors, err)
+	}
+}
+
+// fileError records an error reading or rendering the given source file.
+func (ps *problems) fileError(file string, err error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if ps.files == nil {
+		ps.files = make(map[string][]error)
+	}
+	ps.files[file] = append(ps.files[file], err)
+}
+
+// pageError records an error writing the page with the given name.
+func (ps *problems) pageError(name string, err error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if ps.pages == nil {
+		ps.pages = make(map[string][]error)
+	}
+	ps.pages[name] = append(ps.pages[name], err)
+}
+
+// stepError records the error of the given step, replacing the errors of the last time it ran.
+// A nil error clears them. Errors joined with errors.Join are recorded separately.
+func (ps *problems) stepError(step string, err error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if err == nil {
+		delete(ps.steps, step)
+		return
+	}
+	if ps.steps == nil {
+		ps.steps = make(map[string][]error)
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		ps.steps[step] = joined.Unwrap()
+	} else {
+		ps.steps[step] = []error{err}
+	}
+}
+
+// clearFile forgets the errors of the given source file, or of every file in it if it is a directory.
+func (ps *problems) clearFile(file string) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	for name := range ps.files {
+		if name == file || strings.HasPrefix(name, file+string(filepath.Separator)) {
+			delete(ps.files, name)
+		}
+	}
+}
+
+// clearPage forgets the errors of the page with the given name.
+func (ps *problems) clearPage(name string) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	delete(ps.pages, name)
+}
+
+// warn records a warning, which only fails the build in strict mode.
+func (ps *problems) warn(err error) {
+	if ps.strict {
+		ps.error(err)
+		return
+	}
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.warnings = append(ps.warnings, err)
+}
+
+// failed reports whether any errors were recorded.
+func (ps *problems) failed() bool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return len(ps.errors) > 0 || len(ps.files) > 0 || len(ps.pages) > 0 || len(ps.steps) > 0
+}
+
+// err returns the recorded errors as a *BuildError, or nil if there were none.
+func (ps *problems) err() error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	errs := slices.Clone(ps.errors)
+	for _, m := range []map[string][]error{ps.files, ps.pages, ps.steps} {
+		names := make([]string, 0, len(m))
+		for name := range m {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			errs = append(errs, m[name]...)
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return &BuildError{Errors: errs}
+}
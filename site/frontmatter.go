@@ -0,0 +1,291 @@
+package site
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Delimiters of the supported front matter formats.
+// JSON front matter is a single object, starting with an opening brace.
+var (
+	frontMatter     = []byte("+++")
+	yamlFrontMatter = []byte("---")
+	jsonFrontMatter = []byte("{")
+)
+
+// matches the line number TOML and YAML errors start with, e.g. "toml: line 3 (last key "title"): ..."
+var errorLine = regexp.MustCompile(`^(toml|yaml): line (\d+):? ?`)
+
+// Layouts of JSON strings that are turned into dates, matching TOML's date types
+var dateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+// readFrontMatter reads the front matter from the start of r, line by line,
+// leaving r positioned at the start of the body.
+// It returns the front matter, its format ("toml", "yaml" or "json") and the line it starts on.
+// If r does not start with front matter, nothing is read and the front matter returned is nil.
+func readFrontMatter(r *bufio.Reader) ([]byte, string, int, error) {
+	prefix, _ := r.Peek(len(frontMatter))
+	var format string
+	var delimiter []byte
+	switch {
+	case bytes.Equal(prefix, frontMatter):
+		format, delimiter = "toml", frontMatter
+	case bytes.Equal(prefix, yamlFrontMatter):
+		format, delimiter = "yaml", yamlFrontMatter
+	case bytes.HasPrefix(prefix, jsonFrontMatter) && isJSONFrontMatter(r):
+		format = "json"
+	default:
+		return nil, "", 0, nil
+	}
+
+	var buf bytes.Buffer
+	for n := 1; ; n++ {
+		line, err := r.ReadBytes('\n')
+		trimmed := bytes.TrimRight(line, " \t\r\n")
+
+		if format == "json" {
+			// the JSON object is part of the front matter and ends at a line with just a closing brace,
+			// or at the first closing brace that makes it valid
+			buf.Write(line)
+			if bytes.Equal(trimmed, []byte("}")) || (bytes.HasSuffix(trimmed, []byte("}")) && json.Valid(buf.Bytes())) {
+				return buf.Bytes(), format, 1, nil
+			}
+		} else if n > 1 {
+			// the delimiter lines are not part of the front matter
+			if bytes.Equal(trimmed, delimiter) {
+				return buf.Bytes(), format, 2, nil
+			}
+			buf.Write(line)
+		}
+
+		if err == io.EOF {
+			return nil, "", 0, errors.New("missing closing front-matter identifier")
+		}
+		if err != nil {
+			return nil, "", 0, err
+		}
+	}
+}
+
+// isJSONFrontMatter reports whether r, which starts with an opening brace, starts with a JSON object.
+// The body of a djot or Markdown file may start with a brace as well, for example in block attributes.
+func isJSONFrontMatter(r *bufio.Reader) bool {
+	// the first line of longer front matter is just the opening brace
+	start, _ := r.Peek(r.Size())
+	if line, _, _ := bytes.Cut(start, []byte("\n")); bytes.Equal(bytes.TrimRight(line, " \t\r"), jsonFrontMatter) {
+		return true
+	}
+
+	// an object that does not fit in the buffer is assumed to be front matter
+	var v map[string]any
+	err := json.NewDecoder(bytes.NewReader(start)).Decode(&v)
+	return err == nil || (errors.Is(err, io.ErrUnexpectedEOF) && len(start) == r.Size())
+}
+
+// decodeFrontMatter sets the fields and meta of the given page from the given front matter.
+func decodeFrontMatter(p *Page, format string, data []byte) error {
+	if format == "toml" {
+		meta := make(map[string]any)
+		if err := toml.Unmarshal(data, p); err != nil {
+			return err
+		}
+		if err := toml.Unmarshal(data, &meta); err != nil {
+			return err
+		}
+		p.Meta = meta
+		p.Attrs = p.Meta
+		return nil
+	}
+
+	var meta map[string]any
+	switch format {
+	case "yaml":
+		if err := yaml.Unmarshal(data, &meta); err != nil {
+			return err
+		}
+	case "json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		if err := dec.Decode(&meta); err != nil {
+			return err
+		}
+	}
+
+	normalized, err := normalizeMeta(meta, format)
+	if err != nil {
+		return fmt.Errorf("invalid front matter: %w", err)
+	}
+	meta, _ = normalized.(map[string]any)
+	if meta == nil {
+		meta = make(map[string]any)
+	}
+
+	// re-encode as TOML, so the fields of the page are set exactly like they are from TOML front matter
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(meta); err != nil {
+		return err
+	}
+	if err := toml.Unmarshal(buf.Bytes(), p); err != nil {
+		// line numbers refer to the re-encoded front matter, so leave them out
+		return fmt.Errorf("invalid front matter: %s", errorLine.ReplaceAllString(err.Error(), "toml: "))
+	}
+
+	p.Meta = meta
+	p.Attrs = p.Meta
+	return nil
+}
+
+// normalizeMeta converts the given YAML or JSON value to the types TOML uses:
+// int64 for integers, float64 for other numbers, time.Time for dates,
+// []any for arrays and map[string]any for tables. Null values are left out.
+// Integers that do not fit in an int64 are an error, like they are in TOML.
+func normalizeMeta(v any, format string) (any, error) {
+	switch v := v.(type) {
+	case map[string]any:
+		m := make(map[string]any, len(v))
+		for key, value := range v {
+			value, err := normalizeMeta(value, format)
+			if err != nil {
+				return nil, err
+			}
+			if value != nil {
+				m[key] = value
+			}
+		}
+		return m, nil
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for key, value := range v {
+			value, err := normalizeMeta(value, format)
+			if err != nil {
+				return nil, err
+			}
+			if value != nil {
+				m[fmt.Sprint(key)] = value
+			}
+		}
+		return m, nil
+	case []any:
+		s := make([]any, 0, len(v))
+		for _, value := range v {
+			value, err := normalizeMeta(value, format)
+			if err != nil {
+				return nil, err
+			}
+			if value != nil {
+				s = append(s, value)
+			}
+		}
+		return s, nil
+	case int:
+		return int64(v), nil
+	case uint64:
+		if v > math.MaxInt64 {
+			return nil, fmt.Errorf("%d is out of range for int64", v)
+		}
+		return int64(v), nil
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, nil
+		}
+		f, _ := v.Float64()
+		return f, nil
+	case string:
+		// JSON has no date type, so dates are written as strings
+		if format == "json" {
+			for _, layout := range dateLayouts {
+				if t, err := time.Parse(layout, v); err == nil {
+					return t, nil
+				}
+			}
+		}
+		return v, nil
+	}
+	return v, nil
+}
+
+// frontMatterError returns the given front matter error prefixed with the file and line it occurred at.
+// start is the line in the file at which the front matter data starts.
+func frontMatterError(file string, start int, data []byte, err error) error {
+	msg := err.Error()
+	line := 1
+	if m := errorLine.FindStringSubmatch(msg); m != nil {
+		line, _ = strconv.Atoi(m[2])
+		msg = m[1] + ": " + msg[len(m[0]):]
+	}
+
+	// parse errors know their exact offset, which is more precise
+	// than the line TOML reports for unexpected newlines.
+	// JSON errors only report their offset.
+	var pe toml.ParseError
+	var se *json.SyntaxError
+	if errors.As(err, &pe) && pe.Position.Start <= len(data) {
+		line = bytes.Count(data[:pe.Position.Start], []byte("\n")) + 1
+	} else if errors.As(err, &se) && se.Offset > 0 && int(se.Offset) <= len(data) {
+		// the offset is just past the offending character
+		line = bytes.Count(data[:se.Offset-1], []byte("\n")) + 1
+		msg = "json: " + msg
+	}
+
+	return &FileError{File: file, Line: start + line - 1, Err: errors.New(msg)}
+}
+
+// parse reads the front matter of this page from r and keeps the body around until the page is rendered.
+func (p *Page) parse(r io.Reader) error {
+	br := bufio.NewReader(r)
+	fm, format, start, err := readFrontMatter(br)
+	if err != nil {
+		return &FileError{File: p.Filepath, Line: 1, Err: err}
+	}
+
+	if fm != nil {
+		if err := decodeFrontMatter(p, format, fm); err != nil {
+			return frontMatterError(p.Filepath, start, fm, err)
+		}
+	}
+
+	p.sourceLine = bodyLine(fm, format, start)
+	p.source, err = io.ReadAll(br)
+	return err
+}
+
+// bodyLine returns the line the body starts on, after the given front matter read by readFrontMatter.
+func bodyLine(fm []byte, format string, start int) int {
+	if fm == nil {
+		return 1
+	}
+
+	// the closing delimiter of TOML and YAML is not part of the front matter
+	line := start + bytes.Count(fm, []byte("\n"))
+	if format != "json" {
+		line++
+	}
+	return line
+}
+
+// parseFrontMatter reads the source file of the given page, in a single pass.
+func parseFrontMatter(p *Page) error {
+	fh, err := os.Open(p.Filepath)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	return p.parse(fh)
+}
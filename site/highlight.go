@@ -0,0 +1,155 @@
+package site
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+)
+
+// Default style for highlighted code blocks
+const defaultHighlightStyle = "github"
+
+// HighlightConfig configures syntax highlighting of code blocks, from the [markup.highlight] configuration table.
+// Code blocks are only highlighted if the table is present.
+type HighlightConfig struct {
+	// Name of the Chroma style to use, e.g. "monokai". Defaults to "github".
+	Style string `toml:"style"`
+
+	// Use CSS classes instead of inline styles. See "gozer highlight-css" for the matching stylesheet.
+	Classes bool `toml:"classes"`
+
+	// Show line numbers in every code block
+	LineNumbers bool `toml:"line_numbers"`
+
+	// Whether code blocks are highlighted
+	enabled bool
+}
+
+// codeOptions are the options of a single code block, set in the info string of its fence
+// in Markdown, e.g. ```go {linenos=true hl_lines="2 4-5"}, or in its attributes in djot.
+type codeOptions struct {
+	lang        string
+	lineNumbers bool
+	lineStart   int
+	highlight   [][2]int
+}
+
+var (
+	codeAttribute = regexp.MustCompile(`(\w+)\s*=\s*("[^"]*"|\[[^\]]*\]|[^\s,}]+)`)
+	lineRange     = regexp.MustCompile(`(\d+)(?:-(\d+))?`)
+)
+
+// parseCodeInfo parses the info string of a fenced code block in Markdown: the language,
+// optionally followed by attributes between braces.
+func (c *HighlightConfig) parseCodeInfo(info string) codeOptions {
+	lang, attrs, _ := strings.Cut(strings.TrimSpace(info), "{")
+	if i := strings.IndexAny(lang, " \t"); i >= 0 {
+		lang = lang[:i]
+	}
+
+	values := make(map[string]string)
+	for _, m := range codeAttribute.FindAllStringSubmatch(attrs, -1) {
+		values[m[1]] = m[2]
+	}
+	return c.codeOptions(lang, values)
+}
+
+// codeOptions returns the options of a code block in the given language with the given attributes.
+// The linenos attribute overrides the line_numbers setting, linenostart sets the first line number
+// and hl_lines lists the lines or line ranges to highlight, e.g. "2 4-5".
+func (c *HighlightConfig) codeOptions(lang string, attrs map[string]string) codeOptions {
+	opts := codeOptions{
+		lang:        lang,
+		lineNumbers: c.LineNumbers,
+		lineStart:   1,
+	}
+
+	if v, ok := attrs["linenos"]; ok {
+		v = strings.Trim(v, `"`)
+		opts.lineNumbers = v != "false" && v != ""
+	}
+	if v, err := strconv.Atoi(strings.Trim(attrs["linenostart"], `"`)); err == nil {
+		opts.lineStart = v
+	}
+	for _, m := range lineRange.FindAllStringSubmatch(attrs["hl_lines"], -1) {
+		start, _ := strconv.Atoi(m[1])
+		end := start
+		if m[2] != "" {
+			end, _ = strconv.Atoi(m[2])
+		}
+		opts.highlight = append(opts.highlight, [2]int{start, end})
+	}
+
+	return opts
+}
+
+// style returns the configured Chroma style, or an error if there is no style with that name.
+func (c *HighlightConfig) style() (*chroma.Style, error) {
+	name := c.Style
+	if name == "" {
+		name = defaultHighlightStyle
+	}
+
+	style, ok := styles.Registry[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown highlight style %q", name)
+	}
+	return style, nil
+}
+
+// formatter returns the HTML formatter for a code block with the given options.
+func (c *HighlightConfig) formatter(opts codeOptions) *chromahtml.Formatter {
+	return chromahtml.New(
+		chromahtml.WithClasses(c.Classes),
+		chromahtml.WithLineNumbers(opts.lineNumbers),
+		chromahtml.BaseLineNumber(opts.lineStart),
+		chromahtml.HighlightLines(opts.highlight),
+	)
+}
+
+// highlight writes code as highlighted HTML.
+func (c *HighlightConfig) highlight(w io.Writer, code string, opts codeOptions) error {
+	lexer := lexers.Get(opts.lang)
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+
+	style, err := c.style()
+	if err != nil {
+		return err
+	}
+
+	iterator, err := chroma.Coalesce(lexer).Tokenise(nil, code)
+	if err != nil {
+		return err
+	}
+	return c.formatter(opts).Format(w, style, iterator)
+}
+
+// WriteHighlightCSS writes the stylesheet for code blocks highlighted with CSS classes,
+// in the style configured in the configuration file of the site in opts.
+func WriteHighlightCSS(w io.Writer, opts Options) error {
+	if opts.ConfigFile == "" {
+		opts.ConfigFile = "config.toml"
+	}
+
+	s := &Site{}
+	configFile := filepath.Join(opts.RootDir, opts.ConfigFile)
+	if err := parseConfig(s, configFile); err != nil {
+		return fmt.Errorf("error reading configuration file at %s: %w", configFile, err)
+	}
+
+	style, err := s.Markup.Highlight.style()
+	if err != nil {
+		return err
+	}
+	return chromahtml.New(chromahtml.WithClasses(true)).WriteCSS(w, style)
+}
@@ -0,0 +1,116 @@
+package site
+
+import (
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/util"
+)
+
+// MarkdownConfig configures the Markdown converter, from the [markup.markdown] configuration table.
+type MarkdownConfig struct {
+	// GitHub Flavored Markdown: tables, strikethrough, autolinks and task lists. Defaults to true.
+	GFM bool `toml:"gfm"`
+
+	// Footnotes, e.g. "text[^1]" with "[^1]: note" below it. Defaults to true.
+	Footnotes bool `toml:"footnotes"`
+
+	// Definition lists, with a definition on a line starting with ": " below its term
+	DefinitionList bool `toml:"definition_list"`
+
+	// Smart quotes, dashes and ellipses
+	Typographer bool `toml:"typographer"`
+
+	// Attributes on headings, e.g. "## Title {#id .class}"
+	Attributes bool `toml:"attributes"`
+
+	// Generate an id attribute for every heading from its text. Defaults to true.
+	AutoHeadingIDs bool `toml:"auto_heading_ids"`
+
+	// Render newlines in paragraphs as line breaks
+	HardWraps bool `toml:"hard_wraps"`
+
+	// Render raw HTML and potentially dangerous links. Defaults to true; disable it for untrusted content.
+	Unsafe bool `toml:"unsafe"`
+}
+
+// newMarkdown returns the Markdown converter for the given markup configuration.
+func newMarkdown(markup MarkupConfig) goldmark.Markdown {
+	config := markup.Markdown
+
+	var extensions []goldmark.Extender
+	if config.GFM {
+		extensions = append(extensions, extension.GFM)
+	}
+	if config.Footnotes {
+		extensions = append(extensions, extension.Footnote)
+	}
+	if config.DefinitionList {
+		extensions = append(extensions, extension.DefinitionList)
+	}
+	if config.Typographer {
+		extensions = append(extensions, extension.Typographer)
+	}
+
+	var parserOptions []parser.Option
+	if config.Attributes {
+		parserOptions = append(parserOptions, parser.WithAttribute())
+	}
+	if config.AutoHeadingIDs {
+		parserOptions = append(parserOptions, parser.WithAutoHeadingID())
+	}
+
+	var rendererOptions []renderer.Option
+	if config.HardWraps {
+		rendererOptions = append(rendererOptions, html.WithHardWraps())
+	}
+	if config.Unsafe {
+		rendererOptions = append(rendererOptions, html.WithUnsafe())
+	}
+	if markup.Highlight.enabled {
+		rendererOptions = append(rendererOptions,
+			renderer.WithNodeRenderers(util.Prioritized(&codeBlockRenderer{config: markup.Highlight}, 100)))
+	}
+
+	return goldmark.New(
+		goldmark.WithExtensions(extensions...),
+		goldmark.WithParserOptions(parserOptions...),
+		goldmark.WithRendererOptions(rendererOptions...),
+	)
+}
+
+// codeBlockRenderer renders fenced code blocks as highlighted HTML.
+type codeBlockRenderer struct {
+	config HighlightConfig
+}
+
+func (r *codeBlockRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(ast.KindFencedCodeBlock, r.renderFencedCodeBlock)
+}
+
+func (r *codeBlockRenderer) renderFencedCodeBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+
+	n := node.(*ast.FencedCodeBlock)
+	var info string
+	if n.Info != nil {
+		info = string(n.Info.Segment.Value(source))
+	}
+
+	var code []byte
+	lines := n.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		line := lines.At(i)
+		code = append(code, line.Value(source)...)
+	}
+
+	if err := r.config.highlight(w, string(code), r.config.parseCodeInfo(info)); err != nil {
+		return ast.WalkStop, err
+	}
+	return ast.WalkSkipChildren, nil
+}
@@ -0,0 +1,108 @@
+package site
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"github.com/yuin/goldmark"
+)
+
+// MarkupConfig configures how content files are converted to HTML, from the [markup] configuration table.
+// Pages can override it with a markup table in their front matter.
+type MarkupConfig struct {
+	// Markdown extensions and rendering options
+	Markdown MarkdownConfig `toml:"markdown"`
+
+	// Djot rendering options
+	Djot DjotConfig `toml:"djot"`
+
+	// Syntax highlighting of code blocks
+	Highlight HighlightConfig `toml:"highlight"`
+
+	// Heading anchors and the table of contents
+	Headings HeadingsConfig `toml:"headings"`
+}
+
+// defaultMarkup returns the markup configuration of a site that does not configure it.
+func defaultMarkup() MarkupConfig {
+	return MarkupConfig{
+		Markdown: MarkdownConfig{
+			GFM:            true,
+			Footnotes:      true,
+			AutoHeadingIDs: true,
+			Unsafe:         true,
+		},
+		Djot: DjotConfig{
+			SmartPunctuation: true,
+			Attributes:       true,
+			AutoHeadingIDs:   true,
+		},
+		Headings: HeadingsConfig{
+			TocStartLevel: 2,
+			TocEndLevel:   3,
+		},
+	}
+}
+
+// markdowns holds the Markdown converters for pages that override the markup configuration of the site,
+// so pages with the same overrides share a converter.
+type markdowns struct {
+	mu         sync.Mutex
+	converters map[MarkupConfig]goldmark.Markdown
+}
+
+func (m *markdowns) get(markup MarkupConfig) goldmark.Markdown {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if md, ok := m.converters[markup]; ok {
+		return md
+	}
+	if m.converters == nil {
+		m.converters = make(map[MarkupConfig]goldmark.Markdown)
+	}
+	md := newMarkdown(markup)
+	m.converters[markup] = md
+	return md
+}
+
+// pageMarkup returns the markup configuration for the given page: that of the site,
+// with the values in the markup table of the page's front matter taking precedence.
+func (s *Site) pageMarkup(p *Page) (MarkupConfig, bool, error) {
+	overrides, ok := p.Meta["markup"].(map[string]any)
+	if !ok {
+		return s.Markup, false, nil
+	}
+
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(map[string]any{"markup": overrides}); err != nil {
+		return s.Markup, false, fmt.Errorf("invalid markup configuration: %w", err)
+	}
+
+	page := struct {
+		Markup MarkupConfig `toml:"markup"`
+	}{s.Markup}
+	md, err := toml.Decode(buf.String(), &page)
+	if err != nil {
+		return s.Markup, false, fmt.Errorf("invalid markup configuration: %w", err)
+	}
+	if md.IsDefined("markup", "highlight") {
+		page.Markup.Highlight.enabled = true
+		if _, err := page.Markup.Highlight.style(); err != nil {
+			return s.Markup, false, err
+		}
+	}
+
+	return page.Markup, true, nil
+}
+
+// markdown returns the Markdown converter for the given page.
+func (s *Site) markdown(p *Page) (goldmark.Markdown, error) {
+	markup, override, err := s.pageMarkup(p)
+	if err != nil || !override {
+		return s.md, err
+	}
+	return s.markdowns.get(markup), nil
+}
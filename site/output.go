@@ -0,0 +1,284 @@
+package site
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// output writes files to the output directory and keeps track of every file written during a build.
+type output struct {
+	// Directory files are written to
+	dir string
+
+	mu    sync.Mutex
+	files map[string]bool
+}
+
+func newOutput(dir string) *output {
+	return &output{
+		dir:   dir,
+		files: make(map[string]bool),
+	}
+}
+
+// create creates the file with the given name, relative to the output directory.
+// Names outside the output directory are rejected.
+func (o *output) create(name string) (*os.File, error) {
+	if !filepath.IsLocal(name) {
+		return nil, fmt.Errorf("invalid output file %q: not inside the output directory", name)
+	}
+
+	dest := filepath.Join(o.dir, name)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return nil, err
+	}
+
+	o.mu.Lock()
+	o.files[filepath.Clean(name)] = true
+	o.mu.Unlock()
+
+	return os.Create(dest)
+}
+
+// writeFile writes data to the file with the given name, relative to the output directory.
+func (o *output) writeFile(name string, data []byte) error {
+	fh, err := o.create(name)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	_, err = fh.Write(data)
+	return err
+}
+
+// executeTemplate renders the given template with data to the file with the given name.
+func (o *output) executeTemplate(tmpl *template.Template, name string, data any) error {
+	fh, err := o.create(name)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	// buffer output, as templates write in many small chunks
+	wr := bufio.NewWriter(fh)
+	if err := tmpl.Execute(wr, data); err != nil {
+		return err
+	}
+	return wr.Flush()
+}
+
+// copyDir copies all files in src to the output directory and returns their names.
+func (o *output) copyDir(src string) ([]string, error) {
+	return o.copyPath(src, src)
+}
+
+// copyPath copies the file or directory at path to the output directory, at its path relative to root.
+// It returns the names of the files copied, relative to the output directory.
+func (o *output) copyPath(root string, path string) ([]string, error) {
+	var names []string
+	err := filepath.WalkDir(path, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		name, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			o.mu.Lock()
+			o.files[filepath.Clean(name)] = true
+			o.mu.Unlock()
+			names = append(names, filepath.Clean(name))
+		}
+		return copyFile(path, d, filepath.Join(o.dir, name))
+	})
+	return names, err
+}
+
+// remove removes the files with the given names from the output directory,
+// along with any directories left empty.
+func (o *output) remove(names ...string) error {
+	for _, name := range names {
+		if err := os.RemoveAll(filepath.Join(o.dir, name)); err != nil {
+			return err
+		}
+
+		for dir := filepath.Dir(name); dir != "." && dir != string(filepath.Separator); dir = filepath.Dir(dir) {
+			if entries, err := os.ReadDir(filepath.Join(o.dir, dir)); err != nil || len(entries) > 0 {
+				break
+			}
+			if err := os.Remove(filepath.Join(o.dir, dir)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// removeStale removes all files from the output directory that were not written during this build,
+// for example because their source file was deleted or renamed, and any directories left empty.
+func (o *output) removeStale() error {
+	var dirs []string
+	err := filepath.WalkDir(o.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			dirs = append(dirs, path)
+			return nil
+		}
+
+		name, err := filepath.Rel(o.dir, path)
+		if err != nil {
+			return err
+		}
+		if !o.files[name] {
+			return os.Remove(path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// remove empty directories, deepest first
+	for i := len(dirs) - 1; i > 0; i-- {
+		if entries, err := os.ReadDir(dirs[i]); err == nil && len(entries) == 0 {
+			if err := os.Remove(dirs[i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// isInside reports whether path is the given directory or inside it.
+func isInside(path string, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// checkOutputDir returns an error if removing the given output directory could delete anything other than build output,
+// for example because it is the project root or contains the content directory.
+func checkOutputDir(dir string, rootPath string) error {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	absRoot, err := filepath.Abs(rootPath)
+	if err != nil {
+		return err
+	}
+
+	if absDir == filepath.Dir(absDir) {
+		return fmt.Errorf("refusing to use filesystem root %s as output directory", dir)
+	}
+
+	sources := []string{
+		filepath.Join(absRoot, "content"),
+		filepath.Join(absRoot, "templates"),
+		filepath.Join(absRoot, "public"),
+	}
+	protected := append([]string{absRoot}, sources...)
+	if home, err := os.UserHomeDir(); err == nil {
+		protected = append(protected, home)
+	}
+
+	for _, d := range protected {
+		// the output directory may not be or contain any of these directories
+		if isInside(d, absDir) {
+			return fmt.Errorf("refusing to use %s as output directory, as it contains %s", dir, d)
+		}
+	}
+
+	for _, d := range sources {
+		// nor be inside them, as its files would be read or copied as part of the site
+		if isInside(absDir, d) {
+			return fmt.Errorf("refusing to use %s as output directory, as it is inside %s", dir, d)
+		}
+	}
+
+	return nil
+}
+
+// newStagingDir creates an empty directory next to the given output directory to build into.
+func newStagingDir(dir string) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return "", err
+	}
+	return os.MkdirTemp(filepath.Dir(dir), "."+filepath.Base(dir)+"-")
+}
+
+// swapDir replaces the output directory with the staging directory.
+// The old output directory is moved aside first and removed once the new one is in place,
+// so the output directory always contains a complete build.
+func swapDir(staging string, dir string) error {
+	old := filepath.Join(filepath.Dir(dir), fmt.Sprintf(".%s-old-%d", filepath.Base(dir), time.Now().UnixNano()))
+	if err := os.Rename(dir, old); err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		old = ""
+	}
+
+	if err := os.Rename(staging, dir); err != nil {
+		// put the old output back
+		if old != "" {
+			_ = os.Rename(old, dir)
+		}
+		return err
+	}
+
+	// staging directories are created with restricted permissions
+	if err := os.Chmod(dir, 0755); err != nil {
+		return err
+	}
+
+	if old != "" {
+		return os.RemoveAll(old)
+	}
+	return nil
+}
+
+func copyFile(src string, d fs.DirEntry, dest string) error {
+	// if it's a dir, just re-create it in build/
+	if d.IsDir() {
+		err := os.MkdirAll(dest, 0755)
+		if err != nil && !errors.Is(err, os.ErrExist) {
+			return err
+		}
+
+		return nil
+	}
+
+	// open source file
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	// create dest file
+	fh, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	// copy src content into dest content
+	_, err = io.Copy(fh, in)
+	return err
+}
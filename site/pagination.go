@@ -0,0 +1,103 @@
+package site
+
+import (
+	"strconv"
+)
+
+// Paginator holds a single page of items for a paginated list page.
+type Paginator struct {
+	// Items on this page
+	Items []Page
+
+	// Number of this page, starting at 1
+	PageNumber int
+
+	// Total number of pages
+	TotalPages int
+
+	// Total number of items across all pages
+	TotalItems int
+
+	// URL path for this page, relative to site URL
+	UrlPath string
+
+	// The full URL to this page (incl. site URL)
+	Permalink string
+
+	// The full URLs to the first, last, previous and next page.
+	// PrevUrl and NextUrl are empty if there is no such page.
+	FirstUrl string
+	LastUrl  string
+	PrevUrl  string
+	NextUrl  string
+}
+
+// HasPrev reports whether there is a page before this one.
+func (p *Paginator) HasPrev() bool {
+	return p.PrevUrl != ""
+}
+
+// HasNext reports whether there is a page after this one.
+func (p *Paginator) HasNext() bool {
+	return p.NextUrl != ""
+}
+
+// listItems returns the pages that are listed on the given list page.
+func (s *Site) listItems(p *Page) []Page {
+	if p.term != nil {
+		return p.term.Pages
+	}
+
+	if p.isSection {
+		items := make([]Page, len(p.Children))
+		for i, c := range p.Children {
+			items[i] = *c
+		}
+		return items
+	}
+
+	return s.Posts
+}
+
+// paginatorPath returns the URL path of the n-th page of the given list page.
+func paginatorPath(urlPath string, n int) string {
+	if n <= 1 {
+		return urlPath
+	}
+	return urlPath + "page/" + strconv.Itoa(n) + "/"
+}
+
+// paginate splits the list items of the given page into pages of p.Paginate items.
+// It always returns at least one (possibly empty) page.
+func (s *Site) paginate(p *Page) []*Paginator {
+	items := s.listItems(p)
+	total := (len(items) + p.Paginate - 1) / p.Paginate
+	if total == 0 {
+		total = 1
+	}
+
+	paginators := make([]*Paginator, total)
+	for i := range paginators {
+		start := i * p.Paginate
+		end := min(start+p.Paginate, len(items))
+		urlPath := paginatorPath(p.UrlPath, i+1)
+		paginators[i] = &Paginator{
+			Items:      items[start:end],
+			PageNumber: i + 1,
+			TotalPages: total,
+			TotalItems: len(items),
+			UrlPath:    urlPath,
+			Permalink:  s.SiteUrl + urlPath,
+			FirstUrl:   s.SiteUrl + paginatorPath(p.UrlPath, 1),
+			LastUrl:    s.SiteUrl + paginatorPath(p.UrlPath, total),
+		}
+		if i > 0 {
+			paginators[i].PrevUrl = s.SiteUrl + paginatorPath(p.UrlPath, i)
+		}
+		if i < total-1 {
+			paginators[i].NextUrl = s.SiteUrl + paginatorPath(p.UrlPath, i+2)
+		}
+	}
+
+	return paginators
+}
@@ -0,0 +1,58 @@
+package site
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// resolveUrlPath returns the URL path of the given page, in order of preference from:
+// the "url" in its front matter, the permalink pattern for its section
+// or the path derived from its file name with the "slug" from its front matter.
+func (s *Site) resolveUrlPath(p *Page) string {
+	if p.Url != "" {
+		return cleanUrlPath(p.Url)
+	}
+
+	slug := p.Slug
+	if slug == "" {
+		slug = path.Base(p.UrlPath)
+	}
+
+	// list pages keep the URL path of their directory
+	if pattern, ok := s.Permalinks[p.Section]; ok && !p.isSection {
+		return cleanUrlPath(expandPermalink(pattern, p, slug))
+	}
+
+	if p.Slug != "" && p.UrlPath != "" {
+		return path.Join(path.Dir(strings.TrimSuffix(p.UrlPath, "/")), p.Slug) + "/"
+	}
+
+	return p.UrlPath
+}
+
+// expandPermalink replaces the placeholders in the given permalink pattern with values of the given page.
+// Supported placeholders are :year, :month, :day, :section, :slug, :title and :filename.
+func expandPermalink(pattern string, p *Page, slug string) string {
+	filename := filepath.Base(p.Filepath)
+	filename = strings.TrimSuffix(filename, filepath.Ext(filename))
+
+	return strings.NewReplacer(
+		":year", p.DatePublished.Format("2006"),
+		":month", p.DatePublished.Format("01"),
+		":day", p.DatePublished.Format("02"),
+		":section", p.Section,
+		":slug", slug,
+		":title", slugify(p.Title),
+		":filename", filename,
+	).Replace(pattern)
+}
+
+// cleanUrlPath turns the given path into a URL path relative to the site URL, with a trailing slash.
+func cleanUrlPath(urlPath string) string {
+	urlPath = path.Clean("/" + urlPath)
+	if urlPath == "/" {
+		return ""
+	}
+	return strings.TrimPrefix(urlPath, "/") + "/"
+}
@@ -0,0 +1,326 @@
+package site
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rebuild updates the output of a site built by Build after the given files changed,
+// writing only the pages, feeds and sitemaps that depend on them.
+//
+// Changes to the configuration file, or templates that were added or removed, cause a full build.
+// The returned Result holds the site to use for the next rebuild. Rebuild always updates the output
+// directory in place and writes every page it can, as if the KeepGoing option was set.
+func (s *Site) Rebuild(ctx context.Context, changed []string) (*Result, error) {
+	timeStart := time.Now()
+	opts := s.options
+	opts.Clean = false
+
+	var content, static []string
+	templatesChanged := false
+	for _, file := range changed {
+		file = filepath.Clean(file)
+		rel, err := filepath.Rel(filepath.Clean(s.RootDir), file)
+		if err != nil {
+			continue
+		}
+
+		dir, _, _ := strings.Cut(filepath.ToSlash(rel), "/")
+		switch {
+		case rel == filepath.Clean(opts.ConfigFile):
+			return Build(ctx, opts)
+		case dir == "content":
+			content = append(content, file)
+		case dir == "templates":
+			templatesChanged = true
+		case dir == "public":
+			static = append(static, file)
+		}
+	}
+
+	// pages are published and expire as time passes, unless the time was set explicitly
+	if s.options.Now.IsZero() {
+		s.now = timeStart
+	}
+	for _, file := range s.deps.scheduledBefore(s.now) {
+		if !slices.Contains(content, file) {
+			content = append(content, file)
+		}
+	}
+
+	// errors in files that are not read or written again, or in steps that do not run again, still fail the site
+	s.problems = problems{strict: opts.WarningsAsErrors, files: s.problems.files, pages: s.problems.pages, steps: s.problems.steps}
+	s.out = newOutput(s.OutputDir)
+
+	// templates are parsed again as a whole, but only pages using a changed template are written again
+	var changedTemplates map[string]bool
+	if templatesChanged {
+		templates, err := newTemplates(s.RootDir)
+		if err != nil {
+			return nil, fmt.Errorf("error reading templates/ directory: %w", err)
+		}
+
+		// adding or removing a template may change the default template of any page
+		next := analyzeTemplates(templates)
+		var ok bool
+		if changedTemplates, ok = s.deps.changedTemplates(next); !ok {
+			return Build(ctx, opts)
+		}
+		s.templates = templates
+		s.deps.templates = next
+
+		// shortcodes are expanded in the content of pages, so pages using a changed shortcode are read again
+		for _, p := range s.Pages {
+			if s.deps.usesShortcodes(p.Filepath, changedTemplates) && !slices.Contains(content, p.Filepath) {
+				content = append(content, p.Filepath)
+			}
+		}
+	}
+
+	// remember the neighbours of every post, to tell which pages showing them must be written again
+	oldNeighbours := make(map[string][4]string, len(s.Posts))
+	for _, p := range s.Posts {
+		oldNeighbours[p.Filepath] = s.neighbourNames(&p)
+	}
+
+	// remember how the pages read again look in lists, to tell which list pages must be written again
+	oldListings := make(map[string]string)
+	for _, p := range s.Pages {
+		if slices.ContainsFunc(content, func(file string) bool { return isInside(p.Filepath, file) }) {
+			oldListings[p.Filepath] = listing(&p)
+		}
+	}
+
+	changedPages, err := s.rereadContent(content)
+	if err != nil {
+		return nil, err
+	}
+
+	// a page that was added, removed or changed anything but its content changes the lists it is in
+	listsChanged := false
+	for _, p := range s.Pages {
+		if changedPages[p.Filepath] {
+			old, ok := oldListings[p.Filepath]
+			listsChanged = listsChanged || !ok || old != listing(&p)
+			delete(oldListings, p.Filepath)
+		}
+	}
+	listsChanged = listsChanged || len(oldListings) > 0
+	if len(content) > 0 {
+		s.linkPages()
+		s.problems.stepError("taxonomies", s.collectTaxonomies())
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// decide which pages to write again
+	pages := append(s.taxonomyPages(), s.Pages...)
+	names := make(map[string]bool, len(pages))
+	var dirty []Page
+	for _, p := range pages {
+		name := pageName(&p)
+		names[name] = true
+
+		_, built := s.deps.outputs[name]
+		td := s.deps.templates[p.Template]
+		switch {
+		case !built, changedPages[p.Filepath]:
+		case s.deps.usesTemplate(p.Template, changedTemplates):
+		case len(content) > 0 && td.list && (listsChanged || td.listContent):
+		case len(content) > 0 && td.neighbours && s.neighboursChanged(&p, oldNeighbours, changedPages):
+		default:
+			continue
+		}
+		dirty = append(dirty, p)
+	}
+
+	// remove the output of pages that no longer exist
+	for name, files := range s.deps.outputs {
+		if !names[name] {
+			if err := s.out.remove(files...); err != nil {
+				s.problems.warn(fmt.Errorf("error removing output of %s: %w", name, err))
+			}
+			delete(s.deps.outputs, name)
+			s.problems.clearPage(name)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, p := range dirty {
+		wg.Add(1)
+
+		go func(p Page) {
+			defer wg.Done()
+
+			name := pageName(&p)
+			s.deps.mu.Lock()
+			old := s.deps.outputs[name]
+			s.deps.mu.Unlock()
+
+			s.problems.clearPage(name)
+			if err := s.buildPage(&p); err != nil {
+				s.problems.pageError(name, &FileError{File: name, Err: err})
+			}
+
+			// a paginated page may now be written to fewer files
+			s.deps.mu.Lock()
+			stale := without(old, s.deps.outputs[name])
+			s.deps.mu.Unlock()
+			if err := s.out.remove(stale...); err != nil {
+				s.problems.warn(fmt.Errorf("error removing output of %s: %w", name, err))
+			}
+		}(p)
+	}
+	wg.Wait()
+
+	if len(content) > 0 {
+		err := s.createSitemap()
+		if err != nil {
+			err = fmt.Errorf("error creating sitemap: %w", err)
+		}
+		s.problems.stepError("sitemap", err)
+
+		err = s.createRSSFeed()
+		if err != nil {
+			err = fmt.Errorf("error creating RSS feed: %w", err)
+		}
+		s.problems.stepError("feed", err)
+	}
+
+	// static files are copied one by one, and only files copied from public/ are removed
+	public := filepath.Join(s.RootDir, "public")
+	redirectsChanged := false
+	for _, file := range static {
+		if _, err := os.Stat(file); err != nil {
+			rel, _ := filepath.Rel(public, file)
+			err = s.out.remove(s.deps.removeStatic(rel)...)
+		} else {
+			var names []string
+			names, err = s.out.copyPath(public, file)
+			s.deps.addStatic(names)
+		}
+		if err != nil {
+			s.problems.error(fmt.Errorf("error copying %s: %w", file, err))
+		}
+		redirectsChanged = redirectsChanged || isInside(filepath.Join(public, "_redirects"), file)
+	}
+
+	// the _redirects file is written again after copying public/_redirects
+	if len(content) > 0 || (s.RedirectsFile && redirectsChanged) {
+		oldAliases := s.deps.aliases
+		s.problems.stepError("aliases", s.createAliases(pages))
+		if err := s.out.remove(without(oldAliases, s.deps.aliases)...); err != nil {
+			s.problems.warn(fmt.Errorf("error removing aliases: %w", err))
+		}
+	}
+
+	return &Result{
+		Site:      s,
+		OutputDir: s.OutputDir,
+		Pages:     len(dirty),
+		Warnings:  s.problems.warnings,
+		Duration:  time.Since(timeStart),
+	}, s.problems.err()
+}
+
+// rereadContent reads the given content files or directories again, replacing the pages read from them before.
+// It returns the source files of the pages that were read.
+func (s *Site) rereadContent(files []string) (map[string]bool, error) {
+	changed := make(map[string]bool)
+	for _, file := range files {
+		// a removed or renamed directory takes all pages in it
+		s.Pages = slices.DeleteFunc(s.Pages, func(p Page) bool {
+			return p.Filepath == file || strings.HasPrefix(p.Filepath, file+string(filepath.Separator))
+		})
+		s.problems.clearFile(file)
+
+		if _, err := os.Stat(file); err != nil {
+			continue
+		}
+
+		err := s.walkContent(file, func(file string) {
+			p, err := s.readPage(file)
+			if err != nil {
+				s.problems.fileError(file, err)
+				return
+			}
+			if p == nil {
+				return
+			}
+
+			if err := s.render(p); err != nil {
+				s.problems.fileError(p.Filepath, fileError(p.Filepath, err))
+			}
+
+			// keep pages in the order they are read in by a full build
+			i, _ := slices.BinarySearchFunc(s.Pages, p.Filepath, func(p Page, file string) int {
+				return comparePaths(p.Filepath, file)
+			})
+			s.Pages = slices.Insert(s.Pages, i, *p)
+			changed[p.Filepath] = true
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", file, err)
+		}
+	}
+
+	return changed, nil
+}
+
+// listing returns what pages listing the given page can show of it: everything but its content.
+func listing(p *Page) string {
+	l := *p
+	l.Content, l.TableOfContents, l.WordCount, l.ReadingTime, l.DateModified = "", nil, 0, 0, time.Time{}
+	l.Parent, l.Children, l.source, l.shortcodes, l.Attrs = nil, nil, nil, nil, nil
+	return fmt.Sprintf("%+v", l)
+}
+
+// neighbourNames returns the source files of the neighbouring posts of the given page.
+func (s *Site) neighbourNames(p *Page) [4]string {
+	var names [4]string
+	nb := s.neighbours[p.Filepath]
+	for i, n := range []*Page{nb.prev, nb.next, nb.prevInSection, nb.nextInSection} {
+		if n != nil {
+			names[i] = n.Filepath
+		}
+	}
+	return names
+}
+
+// neighboursChanged reports whether the neighbouring posts of the given page changed,
+// either because other posts are listed next to it or because one of them was read again.
+func (s *Site) neighboursChanged(p *Page, old map[string][4]string, changed map[string]bool) bool {
+	names := s.neighbourNames(p)
+	if names != old[p.Filepath] {
+		return true
+	}
+	for _, name := range names {
+		if changed[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// comparePaths compares file paths in the order filepath.WalkDir visits them.
+func comparePaths(a string, b string) int {
+	return slices.Compare(strings.Split(a, string(filepath.Separator)), strings.Split(b, string(filepath.Separator)))
+}
+
+// without returns the elements of a that are not in b.
+func without(a []string, b []string) []string {
+	var rest []string
+	for _, v := range a {
+		if !slices.Contains(b, v) {
+			rest = append(rest, v)
+		}
+	}
+	return rest
+}
@@ -0,0 +1,91 @@
+package site
+
+import (
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// setSection sets the section and content directory of the given page from its file path.
+func (s *Site) setSection(p *Page) {
+	rel, err := filepath.Rel(filepath.Join(s.RootDir, "content"), p.Filepath)
+	if err != nil {
+		return
+	}
+	rel = filepath.ToSlash(rel)
+
+	p.dir = path.Dir(rel)
+	if p.dir != "." {
+		p.Section, _, _ = strings.Cut(p.dir, "/")
+	}
+
+	name := strings.TrimSuffix(path.Base(rel), path.Ext(rel))
+	p.isSection = name == "_index"
+}
+
+// IsSection reports whether this page is the list page (_index file) of its directory.
+func (p *Page) IsSection() bool {
+	return p.isSection
+}
+
+// defaultTemplate returns the template to use for the given page if its front matter does not specify one.
+// Pages in a section use the template named after their section, e.g. "blog.html",
+// while list pages use the template named after their section's list file, e.g. "blog_index.html".
+func (s *Site) defaultTemplate(p *Page) string {
+	if p.Section != "" && s.templates != nil {
+		name := p.Section + ".html"
+		if p.isSection {
+			name = p.Section + "_index.html"
+		}
+		if s.templates.Lookup(name) != nil {
+			return name
+		}
+	}
+
+	return "default.html"
+}
+
+// linkSections sets the parent of every page to the list page of the closest directory that has one,
+// and adds each page to the children of that list page.
+func (s *Site) linkSections() {
+	sections := make(map[string]*Page)
+	for i := range s.Pages {
+		s.Pages[i].Parent = nil
+		s.Pages[i].Children = nil
+		if s.Pages[i].isSection {
+			sections[s.Pages[i].dir] = &s.Pages[i]
+		}
+	}
+
+	for i := range s.Pages {
+		p := &s.Pages[i]
+
+		// a list page belongs to the directory above it
+		dir := p.dir
+		if p.isSection {
+			if dir == "." {
+				continue
+			}
+			dir = path.Dir(dir)
+		}
+
+		for {
+			if parent, ok := sections[dir]; ok {
+				p.Parent = parent
+				parent.Children = append(parent.Children, p)
+				break
+			}
+			if dir == "." {
+				break
+			}
+			dir = path.Dir(dir)
+		}
+	}
+
+	for _, p := range sections {
+		sort.SliceStable(p.Children, func(i, j int) bool {
+			return lessPage(p.Children[i], p.Children[j])
+		})
+	}
+}
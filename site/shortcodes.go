@@ -0,0 +1,420 @@
+package site
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	shortcodeOpen  = []byte("{{<")
+	shortcodeClose = []byte(">}}")
+)
+
+// Shortcode is the data a shortcode template in templates/shortcodes/ is executed with,
+// for example for {{< figure src="/cat.jpg" caption="My cat" >}}.
+type Shortcode struct {
+	// Name of the shortcode, e.g. "figure"
+	Name string
+
+	// Named parameters, e.g. src="/cat.jpg"
+	Params map[string]string
+
+	// Positional arguments, e.g. "warning" in {{< notice "warning" >}}
+	Args []string
+
+	// Source between the opening and closing tag of a paired shortcode, with its shortcodes expanded.
+	// It is written in the markup of the page and escaped in templates, use InnerHTML to convert it.
+	Inner string
+
+	// Whether the shortcode has a closing tag, e.g. {{< notice >}}...{{< /notice >}}
+	IsPaired bool
+
+	// Page the shortcode is used on. Its content is not yet available.
+	Page *Page
+
+	Site *Site
+}
+
+// Get returns the named parameter with the given name, or the positional argument at the given index.
+// It returns an empty string if there is no such parameter.
+func (sc Shortcode) Get(key any) string {
+	switch k := key.(type) {
+	case string:
+		return sc.Params[k]
+	case int:
+		if k >= 0 && k < len(sc.Args) {
+			return sc.Args[k]
+		}
+	}
+	return ""
+}
+
+// InnerHTML returns the inner content of the shortcode converted to HTML, like the content of the page.
+func (sc Shortcode) InnerHTML() (template.HTML, error) {
+	html, err := sc.Site.convert(sc.Page, []byte(sc.Inner))
+	return template.HTML(html), err
+}
+
+// shortcodeTag is an opening, closing or self-closing shortcode tag in the source of a page.
+type shortcodeTag struct {
+	name    string
+	args    []string
+	params  map[string]string
+	closing bool
+
+	// Whether the tag closes itself, e.g. {{< name />}}
+	selfClosing bool
+
+	// Offsets of the tag in the source
+	start, end int
+}
+
+// shortcodeNode is either text or a shortcode with the nodes between its opening and closing tag.
+type shortcodeNode struct {
+	text   []byte
+	tag    *shortcodeTag
+	inner  []*shortcodeNode
+	paired bool
+
+	// Offset in the source of the end of the closing tag, or of the tag itself if it is not paired
+	end int
+}
+
+// shortcodeError is an error at the given offset in the source of a page.
+type shortcodeError struct {
+	offset int
+	err    error
+}
+
+func (e *shortcodeError) Error() string {
+	return e.err.Error()
+}
+
+// expandShortcodes replaces the shortcodes in the given source of the page with the output of their templates.
+// line is the line of the source file the given source starts on, to report errors at.
+func (s *Site) expandShortcodes(p *Page, source []byte, line int) ([]byte, error) {
+	if !bytes.Contains(source, shortcodeOpen) {
+		s.deps.setShortcodes(p.Filepath, nil)
+		return source, nil
+	}
+
+	nodes, err := parseShortcodes(source)
+	if err == nil {
+		var buf bytes.Buffer
+		names := make(map[string]bool)
+		if err = s.executeShortcodes(&buf, p, source, nodes, names); err == nil {
+			s.deps.setShortcodes(p.Filepath, names)
+			return buf.Bytes(), nil
+		}
+	}
+
+	var se *shortcodeError
+	if errors.As(err, &se) {
+		line += bytes.Count(source[:se.offset], []byte("\n"))
+		err = se.err
+	}
+	return nil, &FileError{File: p.Filepath, Line: line, Err: err}
+}
+
+// executeShortcodes writes the given nodes, executing the template of every shortcode in them.
+// The names of the templates it executes are added to names.
+func (s *Site) executeShortcodes(buf *bytes.Buffer, p *Page, source []byte, nodes []*shortcodeNode, names map[string]bool) error {
+	for _, n := range nodes {
+		if n.tag == nil {
+			buf.Write(n.text)
+			continue
+		}
+
+		name := "shortcodes/" + n.tag.name + ".html"
+		tmpl := s.templates.Lookup(name)
+		if tmpl == nil {
+			return &shortcodeError{offset: n.tag.start, err: fmt.Errorf("unknown shortcode %q: no template %s", n.tag.name, filepath.Join("templates", name))}
+		}
+		names[name] = true
+
+		var inner bytes.Buffer
+		if err := s.executeShortcodes(&inner, p, source, n.inner, names); err != nil {
+			return err
+		}
+
+		var out bytes.Buffer
+		err := tmpl.Execute(&out, Shortcode{
+			Name:     n.tag.name,
+			Params:   n.tag.params,
+			Args:     n.tag.args,
+			Inner:    inner.String(),
+			IsPaired: n.paired,
+			Page:     p,
+			Site:     s,
+		})
+		if err != nil {
+			return &shortcodeError{offset: n.tag.start, err: fmt.Errorf("error executing shortcode %q: %w", n.tag.name, err)}
+		}
+
+		// the newline template files end with is not part of the output, so shortcodes can be used inline
+		html := strings.TrimSuffix(out.String(), "\n")
+
+		switch filepath.Ext(p.Filepath) {
+		case ".md":
+			// Markdown may omit raw HTML, so the output is put back after the page is converted
+			html = p.shortcodePlaceholder(html)
+		case ".dj":
+			// djot has no raw HTML, so the output is written as a raw block or inline
+			html = rawDjot(html, isBlock(source, n.tag.start, n.end))
+		}
+		buf.WriteString(html)
+	}
+	return nil
+}
+
+// shortcodePlaceholder returns the text that stands in for the given shortcode output until the page is converted.
+func (p *Page) shortcodePlaceholder(html string) string {
+	// the output may contain the placeholders of the shortcodes inside it, through .Inner
+	html = p.restoreShortcodes(html)
+
+	if p.shortcodes == nil {
+		p.shortcodes = make(map[string]string)
+	}
+	placeholder := fmt.Sprintf("GOZERSHORTCODE%dX", len(p.shortcodes))
+	p.shortcodes[placeholder] = html
+	return placeholder
+}
+
+// restoreShortcodes replaces the shortcode placeholders in the given converted content with the output of the shortcodes.
+func (p *Page) restoreShortcodes(content string) string {
+	if len(p.shortcodes) == 0 {
+		return content
+	}
+
+	// a shortcode on a line of its own is not part of a paragraph
+	replacements := make([]string, 0, 4*len(p.shortcodes))
+	for placeholder, html := range p.shortcodes {
+		replacements = append(replacements, "<p>"+placeholder+"</p>", html, placeholder, html)
+	}
+	return strings.NewReplacer(replacements...).Replace(content)
+}
+
+// parseShortcodes parses the given source into text and shortcodes. A shortcode with a closing tag
+// later on contains the nodes in between, so shortcodes can be nested.
+func parseShortcodes(source []byte) ([]*shortcodeNode, error) {
+	root := &shortcodeNode{}
+	open := []*shortcodeNode{root}
+
+	// an opening tag without closing tag stands on its own, followed by what it seemed to contain
+	unpair := func() {
+		n := open[len(open)-1]
+		open = open[:len(open)-1]
+		parent := open[len(open)-1]
+		parent.inner = append(parent.inner, n.inner...)
+		n.inner = nil
+	}
+
+	pos := 0
+	for {
+		i := bytes.Index(source[pos:], shortcodeOpen)
+		if i < 0 {
+			break
+		}
+		current := open[len(open)-1]
+		current.inner = append(current.inner, &shortcodeNode{text: source[pos : pos+i]})
+
+		tag, literal, err := parseShortcodeTag(source, pos+i)
+		if err != nil {
+			return nil, err
+		}
+		pos = tag.end
+
+		switch {
+		case literal != nil:
+			current.inner = append(current.inner, &shortcodeNode{text: literal})
+		case tag.closing:
+			depth := len(open) - 1
+			for depth > 0 && open[depth].tag.name != tag.name {
+				depth--
+			}
+			if depth == 0 {
+				return nil, &shortcodeError{offset: tag.start, err: fmt.Errorf("closing shortcode %q without opening tag", tag.name)}
+			}
+			for len(open)-1 > depth {
+				unpair()
+			}
+
+			n := open[depth]
+			n.paired = true
+			n.end = tag.end
+			open = open[:depth]
+		default:
+			n := &shortcodeNode{tag: tag, end: tag.end}
+			current.inner = append(current.inner, n)
+			if !tag.selfClosing {
+				open = append(open, n)
+			}
+		}
+	}
+
+	open[len(open)-1].inner = append(open[len(open)-1].inner, &shortcodeNode{text: source[pos:]})
+	for len(open) > 1 {
+		unpair()
+	}
+	return root.inner, nil
+}
+
+// parseShortcodeTag parses the shortcode tag starting at the given offset, e.g. {{< name "arg" key="value" >}}.
+// A commented tag, e.g. {{</* name */>}}, is returned as the literal text of the tag without comment.
+func parseShortcodeTag(source []byte, start int) (*shortcodeTag, []byte, error) {
+	tag := &shortcodeTag{start: start, params: make(map[string]string)}
+	pos := start + len(shortcodeOpen)
+	fail := func(format string, args ...any) (*shortcodeTag, []byte, error) {
+		return nil, nil, &shortcodeError{offset: start, err: fmt.Errorf(format, args...)}
+	}
+
+	skipSpace := func() {
+		for pos < len(source) && isSpace(source[pos]) {
+			pos++
+		}
+	}
+
+	skipSpace()
+	if bytes.HasPrefix(source[pos:], []byte("/*")) {
+		end := bytes.Index(source[pos:], []byte("*/>}}"))
+		if end < 0 {
+			return fail("unclosed shortcode comment")
+		}
+		tag.end = pos + end + len("*/>}}")
+		literal := append(append([]byte("{{<"), source[pos+2:pos+end]...), shortcodeClose...)
+		return tag, literal, nil
+	}
+	if pos < len(source) && source[pos] == '/' {
+		tag.closing = true
+		pos++
+		skipSpace()
+	}
+
+	nameStart := pos
+	for pos < len(source) && isShortcodeNameChar(source[pos]) {
+		pos++
+	}
+	tag.name = string(source[nameStart:pos])
+	if tag.name == "" {
+		return fail("missing shortcode name")
+	}
+
+	for {
+		skipSpace()
+		rest := source[pos:]
+		switch {
+		case len(rest) == 0:
+			return fail("unclosed shortcode %q: missing %s", tag.name, shortcodeClose)
+		case bytes.HasPrefix(rest, shortcodeClose):
+			tag.end = pos + len(shortcodeClose)
+			return tag, nil, nil
+		case bytes.HasPrefix(rest, []byte("/>}}")) && !tag.closing:
+			tag.selfClosing = true
+			tag.end = pos + len("/>}}")
+			return tag, nil, nil
+		case tag.closing:
+			return fail("closing shortcode %q can not have arguments", tag.name)
+		}
+
+		value, next, err := parseShortcodeValue(source, pos)
+		if err != nil {
+			return fail("invalid arguments to shortcode %q: %s", tag.name, err)
+		}
+		pos = next
+
+		if pos < len(source) && source[pos] == '=' {
+			key := value
+			if value, pos, err = parseShortcodeValue(source, pos+1); err != nil {
+				return fail("invalid value for %q in shortcode %q: %s", key, tag.name, err)
+			}
+			tag.params[key] = value
+			continue
+		}
+		tag.args = append(tag.args, value)
+	}
+}
+
+// parseShortcodeValue parses the argument, key or value starting at the given offset.
+// Values are either quoted with " or `, or end at the first space, "=" or closing tag.
+func parseShortcodeValue(source []byte, pos int) (string, int, error) {
+	if pos >= len(source) {
+		return "", pos, errors.New("unexpected end of file")
+	}
+
+	switch quote := source[pos]; quote {
+	case '"':
+		var b strings.Builder
+		for i := pos + 1; i < len(source); i++ {
+			switch source[i] {
+			case '\\':
+				if i+1 < len(source) {
+					i++
+					b.WriteByte(source[i])
+				}
+			case '"':
+				return b.String(), i + 1, nil
+			default:
+				b.WriteByte(source[i])
+			}
+		}
+		return "", pos, errors.New("unterminated string")
+	case '`':
+		end := bytes.IndexByte(source[pos+1:], '`')
+		if end < 0 {
+			return "", pos, errors.New("unterminated string")
+		}
+		return string(source[pos+1 : pos+1+end]), pos + end + 2, nil
+	}
+
+	start := pos
+	for pos < len(source) && !isSpace(source[pos]) && source[pos] != '=' && !bytes.HasPrefix(source[pos:], shortcodeClose) && !bytes.HasPrefix(source[pos:], []byte("/>}}")) {
+		pos++
+	}
+	if pos == start {
+		return "", pos, fmt.Errorf("unexpected %q", source[pos])
+	}
+	return string(source[start:pos]), pos, nil
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isShortcodeNameChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '/'
+}
+
+// isBlock reports whether the given range of the source is on lines of its own.
+func isBlock(source []byte, start int, end int) bool {
+	lineStart := bytes.LastIndexByte(source[:start], '\n') + 1
+	lineEnd := bytes.IndexByte(source[end:], '\n')
+	if lineEnd < 0 {
+		lineEnd = len(source) - end
+	}
+	return len(bytes.TrimSpace(source[lineStart:start])) == 0 && len(bytes.TrimSpace(source[end:end+lineEnd])) == 0
+}
+
+// rawDjot returns the given HTML as a raw block or inline in djot.
+func rawDjot(html string, block bool) string {
+	// the backticks around raw content must be more than any run of backticks in it
+	longest, run := 0, 0
+	for i := 0; i < len(html); i++ {
+		if html[i] == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+
+	if block {
+		fence := strings.Repeat("`", max(3, longest+1))
+		return fence + " =html\n" + html + "\n" + fence
+	}
+	ticks := strings.Repeat("`", longest+1)
+	return ticks + html + ticks + "{=html}"
+}
@@ -0,0 +1,975 @@
+package site
+
+import (
+	"context"
+	_ "embed"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/yuin/goldmark"
+)
+
+//go:embed sitemap.xsl
+var sitemapXSL []byte
+
+type Site struct {
+	Pages []Page
+	Posts []Page
+
+	Title   string `toml:"title"`
+	SiteUrl string `toml:"url"`
+	RootDir string
+
+	// Names of the front matter keys to group pages by, e.g. ["tags"]
+	TaxonomyNames []string `toml:"taxonomies"`
+
+	// Pages grouped by term, keyed by taxonomy name
+	Taxonomies map[string]*Taxonomy `toml:"-"`
+
+	// Number of items per page for generated term pages. Zero disables pagination.
+	Paginate int `toml:"paginate"`
+
+	// Number of words in generated page summaries. Defaults to 70.
+	SummaryLength int `toml:"summary_length"`
+
+	// URL patterns for pages, keyed by section, e.g. blog = "/:year/:month/:slug/"
+	Permalinks map[string]string `toml:"permalinks"`
+
+	// Whether to write all aliases to a _redirects file
+	RedirectsFile bool `toml:"redirects_file"`
+
+	// Directory to write the site to. Defaults to "build" in the project root.
+	OutputDir string `toml:"output_dir"`
+
+	// How content files are converted to HTML
+	Markup MarkupConfig `toml:"markup"`
+
+	Meta map[string]any `toml:"-"`
+
+	// Deprecated: use Meta.
+	Attrs map[string]any `toml:"-"`
+
+	options Options
+
+	// Templates in the templates/ directory of the project
+	templates *template.Template
+
+	// Markdown converter for this site
+	md goldmark.Markdown
+
+	// Markdown converters for pages overriding the markup configuration
+	markdowns markdowns
+
+	// Time of this build, used to decide which pages are published
+	now time.Time
+
+	// Errors and warnings of the current build
+	problems problems
+
+	// Which output files depend on which source files and templates, for rebuilds
+	deps deps
+
+	// Output directory of the current build
+	out *output
+
+	// Neighbouring posts, keyed by source file of the post
+	neighbours map[string]neighbours
+}
+
+// neighbours holds the posts listed around a post in Posts.
+type neighbours struct {
+	prev          *Page
+	next          *Page
+	prevInSection *Page
+	nextInSection *Page
+}
+
+type Page struct {
+	// Title of this page
+	Title string
+
+	// Template this page uses for rendering. Defaults to "default.html".
+	Template string
+
+	// Time this page was published (parsed from file name or "date" in front matter).
+	DatePublished time.Time `toml:"date"`
+
+	// Time this page was last modified (from filesystem or "lastmod" in front matter).
+	DateModified time.Time `toml:"lastmod"`
+
+	// The full URL to this page (incl. site URL)
+	Permalink string
+
+	// URL path for this page, relative to site URL
+	UrlPath string
+
+	// Path to source file for this page, relative to content root
+	Filepath string
+
+	// Last segment of the URL path, overriding the one parsed from the file name
+	Slug string
+
+	// URL path for this page from front matter, overriding the one derived from the file name
+	Url string
+
+	// Additional URL paths that redirect to this page
+	Aliases []string
+
+	// Whether this page is a draft. Drafts are only built with --drafts.
+	Draft bool
+
+	// Time from which this page is published. Pages with a publish date
+	// in the future are only built with --future.
+	PublishDate time.Time
+
+	// Time after which this page is no longer published. Expired pages
+	// are only built with --expired.
+	ExpiryDate time.Time
+
+	// Number of items per page for list pages. Zero disables pagination.
+	Paginate int
+
+	// Position of this page in lists. Pages with a lower non-zero weight come first.
+	Weight int
+
+	// The HTML content of this page. Empty for generated pages.
+	Content template.HTML `toml:"-"`
+
+	// Headings of this page, nested by level. Use .TableOfContents.HTML to show them as nested lists of links.
+	TableOfContents TableOfContents `toml:"-"`
+
+	// Summary of this page: the content before a <!--more--> marker,
+	// the summary from front matter or the first words of the content.
+	Summary template.HTML
+
+	// Whether the summary leaves out part of the content
+	Truncated bool `toml:"-"`
+
+	// Number of words in the content of this page
+	WordCount int `toml:"-"`
+
+	// Estimated time to read the content of this page, in minutes
+	ReadingTime int `toml:"-"`
+
+	// Name of the top-level content directory this page is in, if any
+	Section string `toml:"-"`
+
+	// The list page (_index file) of the directory this page is in, if any
+	Parent *Page `toml:"-"`
+
+	// Pages in the directory of this list page. Only set on list pages.
+	Children []*Page `toml:"-"`
+
+	// Directory of the source file, relative to the content root
+	dir string
+
+	// Whether this page is the list page of its directory
+	isSection bool
+
+	// Source of this page without front matter, until it is rendered
+	source []byte
+
+	// Line of the source file the body starts on
+	sourceLine int
+
+	// Output of the shortcodes in the source, keyed by the placeholder that stands in for it until the source is converted
+	shortcodes map[string]string
+
+	Meta map[string]any `toml:"-"`
+
+	// Deprecated: use Meta.
+	Attrs map[string]any `toml:"-"`
+
+	// Set for generated taxonomy and term pages
+	taxonomy *Taxonomy
+	term     *Term
+}
+
+// File static hosts serve for missing pages, written from content/404.md
+const notFoundPage = "404.html"
+
+// isNotFound reports whether this page is the page served for missing pages.
+func (p *Page) isNotFound() bool {
+	return p.UrlPath == "404/"
+}
+
+// parseFilename parses the URL path and optional date component from the given file path
+func parseFilename(path string, rootDir string) (string, time.Time) {
+	if rel, err := filepath.Rel(filepath.Join(rootDir, "content"), path); err == nil {
+		path = rel
+	}
+	path = filepath.ToSlash(path)
+	path = strings.TrimSuffix(path, ".md")
+	path = strings.TrimSuffix(path, ".dj")
+	path = strings.TrimSuffix(path, ".html")
+	path = strings.TrimSuffix(path, "_index")
+	path = strings.TrimSuffix(path, "index")
+
+	filename := filepath.Base(path)
+	if len(filename) > 11 && filename[4] == '-' && filename[7] == '-' && filename[10] == '-' {
+		date, err := time.Parse("2006-01-02", filename[0:10])
+		if err == nil {
+			return path[0:len(path)-len(filename)] + filename[11:] + "/", date
+		}
+	}
+
+	if path != "" && !strings.HasSuffix(path, "/") {
+		path += "/"
+	}
+
+	return path, time.Time{}
+}
+
+// convert converts the given source to HTML, based on the file type of the given page.
+func (s *Site) convert(p *Page, source []byte) (string, error) {
+	switch filepath.Ext(p.Filepath) {
+	default:
+		return "", fmt.Errorf("unknown file type %q", filepath.Ext(p.Filepath))
+	case ".md":
+		md, err := s.markdown(p)
+		if err != nil {
+			return "", err
+		}
+		var buf2 strings.Builder
+		if err := md.Convert(source, &buf2); err != nil {
+			return "", err
+		}
+		return p.restoreShortcodes(buf2.String()), nil
+	case ".dj":
+		markup, _, err := s.pageMarkup(p)
+		if err != nil {
+			return "", err
+		}
+		return convertDjot(source, markup)
+	case ".html":
+		return string(source), nil
+	}
+}
+
+func (s *Site) buildPage(p *Page) error {
+	tmpl := s.templates.Lookup(p.Template)
+	if tmpl == nil {
+		return fmt.Errorf("invalid template name: %s", p.Template)
+	}
+
+	nb := s.neighbours[p.Filepath]
+
+	data := map[string]any{
+		"Page":  p,
+		"Posts": s.Posts,
+		"Pages": s.Pages,
+		"Site": map[string]any{
+			"Url":        s.SiteUrl,
+			"Title":      s.Title,
+			"Taxonomies": s.Taxonomies,
+		},
+		"Meta":  s.Meta,
+		"Attrs": s.Meta,
+
+		// If the page is a post, it may have a next and previous post
+		// These may also be nil
+		"Prev":          nb.prev,
+		"Next":          nb.next,
+		"PrevInSection": nb.prevInSection,
+		"NextInSection": nb.nextInSection,
+
+		// Only set on generated taxonomy and term pages
+		"Taxonomy": p.taxonomy,
+		"Term":     p.term,
+
+		// Shorthand for accessing through .Page.Title / .Page.Content / .Page.TableOfContents
+		"Title":           p.Title,
+		"Content":         p.Content,
+		"TableOfContents": p.TableOfContents,
+
+		// Timestamp of build
+		"Now": s.now,
+
+		// Deprecated template variables, use .Site.Url instead
+		"SiteUrl": s.SiteUrl,
+	}
+
+	if p.Paginate <= 0 {
+		name := filepath.Join(p.UrlPath, "index.html")
+		if p.isNotFound() {
+			name = notFoundPage
+		}
+		s.deps.setOutputs(pageName(p), []string{name})
+		return s.out.executeTemplate(tmpl, name, data)
+	}
+
+	// paginated pages are written once for every page of items
+	paginators := s.paginate(p)
+	names := make([]string, len(paginators))
+	for i, paginator := range paginators {
+		names[i] = filepath.Join(paginator.UrlPath, "index.html")
+	}
+	s.deps.setOutputs(pageName(p), names)
+
+	for i, paginator := range paginators {
+		data["Paginator"] = paginator
+		if err := s.out.executeTemplate(tmpl, names[i], data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Options controls where a site is read from and written to, and which content is included in a build.
+type Options struct {
+	// Directory to use as root of the project. Defaults to the working directory.
+	RootDir string
+
+	// Path to the configuration file, relative to the project root. Defaults to "config.toml".
+	ConfigFile string
+
+	// Directory to write the site to, relative to the project root.
+	// Overrides the output_dir configuration key.
+	OutputDir string
+
+	// Include pages marked as draft
+	Drafts bool
+
+	// Include pages with a publish date in the future
+	Future bool
+
+	// Include pages with an expiry date in the past
+	Expired bool
+
+	// Build into an empty directory and replace the output directory with it once done.
+	// Otherwise, the output directory is updated in place and files that were not
+	// written during the build are removed afterwards.
+	Clean bool
+
+	// Continue after errors and write all pages that could be built.
+	// The build still fails, but the output directory is updated.
+	KeepGoing bool
+
+	// Fail the build on warnings, such as files in content/ of an unknown type.
+	WarningsAsErrors bool
+
+	// Time to decide which pages are published. Defaults to the time the build starts.
+	Now time.Time
+}
+
+// isPublished reports whether the given page should be included in the build.
+func (s *Site) isPublished(p *Page) bool {
+	if p.Draft && !s.options.Drafts {
+		return false
+	}
+
+	publishDate := p.PublishDate
+	if publishDate.IsZero() {
+		publishDate = p.DatePublished
+	}
+	if publishDate.After(s.now) && !s.options.Future {
+		return false
+	}
+
+	if !p.ExpiryDate.IsZero() && !p.ExpiryDate.After(s.now) && !s.options.Expired {
+		return false
+	}
+
+	return true
+}
+
+// publishChange returns the time after which the given page is no longer left out of the build
+// because of its publish date, or is left out because of its expiry date. It is zero if there is no such time.
+func (s *Site) publishChange(p *Page) time.Time {
+	publishDate := p.PublishDate
+	if publishDate.IsZero() {
+		publishDate = p.DatePublished
+	}
+	if publishDate.After(s.now) && !s.options.Future {
+		return publishDate
+	}
+	if p.ExpiryDate.After(s.now) && !s.options.Expired {
+		return p.ExpiryDate
+	}
+	return time.Time{}
+}
+
+func (s *Site) AddPageFromFile(file string) error {
+	p, err := s.readPage(file)
+	if err != nil || p == nil {
+		return err
+	}
+
+	s.Pages = append(s.Pages, *p)
+	return nil
+}
+
+// readPage reads the page in the given source file. It returns nil if the page should not be published.
+func (s *Site) readPage(file string) (*Page, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+
+	urlPath, datePublished := parseFilename(file, s.RootDir)
+
+	p := Page{
+		Filepath:      file,
+		UrlPath:       urlPath,
+		Permalink:     s.SiteUrl + urlPath,
+		DatePublished: datePublished,
+		DateModified:  info.ModTime(),
+	}
+	s.setSection(&p)
+
+	// read the source file once; its body is kept on the page until it is rendered
+	if err := parseFrontMatter(&p); err != nil {
+		return nil, err
+	}
+
+	p.UrlPath = s.resolveUrlPath(&p)
+	p.Permalink = s.SiteUrl + p.UrlPath
+
+	if p.Template == "" {
+		p.Template = s.defaultTemplate(&p)
+	}
+
+	// a page that is published or expires later is read again by the first rebuild after that
+	s.deps.setScheduled(file, s.publishChange(&p))
+
+	if !s.isPublished(&p) {
+		return nil, nil
+	}
+
+	return &p, nil
+}
+
+func (s *Site) readContent(dir string) error {
+	// walk over files in "content" directory
+	err := s.walkContent(dir, func(file string) {
+		// a page that can not be read is left out, so the other pages can still be checked
+		if err := s.AddPageFromFile(file); err != nil {
+			s.problems.fileError(file, err)
+		}
+	})
+
+	// render the content of every page once, so it can be shared by
+	// list pages, feeds and the page itself
+	var wg sync.WaitGroup
+	for i := range s.Pages {
+		wg.Add(1)
+		go func(p *Page) {
+			if err := s.render(p); err != nil {
+				s.problems.fileError(p.Filepath, fileError(p.Filepath, err))
+			}
+			wg.Done()
+		}(&s.Pages[i])
+	}
+	wg.Wait()
+
+	s.linkPages()
+	return err
+}
+
+// walkContent calls fn for every page source file in dir, which may also be a single file.
+// Files of an unknown type are reported as a warning.
+func (s *Site) walkContent(dir string, fn func(file string)) error {
+	return filepath.WalkDir(dir, func(file string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		switch filepath.Ext(file) {
+		case ".md", ".dj", ".html":
+			fn(file)
+		default:
+			s.problems.warn(&FileError{File: file, Err: errors.New("unknown file type, skipping")})
+		}
+		return nil
+	})
+}
+
+// linkPages links pages to their section and collects, sorts and links posts.
+// It is called again whenever pages are added or removed.
+func (s *Site) linkPages() {
+	// link pages to the list page of their directory
+	s.linkSections()
+
+	// every page with a date is assumed to be a blog post
+	s.Posts = nil
+	for _, p := range s.Pages {
+		if !p.DatePublished.IsZero() {
+			s.Posts = append(s.Posts, p)
+		}
+	}
+
+	// sort posts by weight and date
+	sort.SliceStable(s.Posts, func(i int, j int) bool {
+		return lessPage(&s.Posts[i], &s.Posts[j])
+	})
+	s.linkPosts()
+}
+
+// lessPage reports whether page a should be listed before page b.
+// Pages with a non-zero weight come first, lowest weight first.
+// Pages with equal weight are listed most recent first.
+func lessPage(a *Page, b *Page) bool {
+	if a.Weight != b.Weight {
+		if a.Weight == 0 || b.Weight == 0 {
+			return b.Weight == 0
+		}
+		return a.Weight < b.Weight
+	}
+	return a.DatePublished.After(b.DatePublished)
+}
+
+// linkPosts stores the neighbouring posts of every post, both in Posts and within its section.
+// Prev is the post listed after it (the older one), Next is the post listed before it (the newer one).
+func (s *Site) linkPosts() {
+	s.neighbours = make(map[string]neighbours, len(s.Posts))
+	lastInSection := make(map[string]*Page)
+	for i := range s.Posts {
+		p := &s.Posts[i]
+		nb := s.neighbours[p.Filepath]
+		if i > 0 {
+			nb.next = &s.Posts[i-1]
+		}
+		if i < len(s.Posts)-1 {
+			nb.prev = &s.Posts[i+1]
+		}
+
+		if newer, ok := lastInSection[p.Section]; ok {
+			nb.nextInSection = newer
+			older := s.neighbours[newer.Filepath]
+			older.prevInSection = p
+			s.neighbours[newer.Filepath] = older
+		}
+		lastInSection[p.Section] = p
+		s.neighbours[p.Filepath] = nb
+	}
+}
+
+func (s *Site) createSitemap() error {
+	type Url struct {
+		XMLName xml.Name `xml:"url"`
+		Loc     string   `xml:"loc"`
+		LastMod string   `xml:"lastmod"`
+	}
+
+	type Envelope struct {
+		XMLName        xml.Name `xml:"urlset"`
+		XMLNS          string   `xml:"xmlns,attr"`
+		SchemaLocation string   `xml:"xsi:schemaLocation,attr"`
+		XSI            string   `xml:"xmlns:xsi,attr"`
+		Image          string   `xml:"xmlns:image,attr"`
+		Urls           []Url    `xml:""`
+	}
+
+	pages := append(s.taxonomyPages(), s.Pages...)
+	urls := make([]Url, 0, len(pages))
+	for _, p := range pages {
+		if p.isNotFound() {
+			continue
+		}
+		urls = append(urls, Url{
+			Loc:     p.Permalink,
+			LastMod: p.DateModified.Format(time.RFC3339),
+		})
+	}
+
+	env := Envelope{
+		SchemaLocation: "http://www.sitemaps.org/schemas/sitemap/0.9 http://www.sitemaps.org/schemas/sitemap/0.9/sitemap.xsd http://www.google.com/schemas/sitemap-image/1.1 http://www.google.com/schemas/sitemap-image/1.1/sitemap-image.xsd",
+		XMLNS:          "http://www.sitemaps.org/schemas/sitemap/0.9",
+		XSI:            "http://www.w3.org/2001/XMLSchema-instance",
+		Image:          "http://www.google.com/schemas/sitemap-image/1.1",
+		Urls:           urls,
+	}
+
+	wr, err := s.out.create("sitemap.xml")
+	if err != nil {
+		return err
+	}
+	defer wr.Close()
+
+	if _, err := wr.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><?xml-stylesheet type="text/xsl" href="/sitemap.xsl"?>`)); err != nil {
+		return err
+	}
+	if err := xml.NewEncoder(wr).Encode(env); err != nil {
+		return err
+	}
+
+	// copy xml stylesheet
+	if err := s.out.writeFile("sitemap.xsl", sitemapXSL); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Site) createRSSFeed() error {
+	type Item struct {
+		Title       string `xml:"title"`
+		Link        string `xml:"link"`
+		Description string `xml:"description"`
+		PubDate     string `xml:"pubDate"`
+		GUID        string `xml:"guid"`
+	}
+
+	type Channel struct {
+		Title         string `xml:"title"`
+		Link          string `xml:"link"`
+		Description   string `xml:"description"`
+		Generator     string `xml:"generator"`
+		LastBuildDate string `xml:"lastBuildDate"`
+		Items         []Item `xml:"item"`
+	}
+
+	type Feed struct {
+		XMLName xml.Name `xml:"rss"`
+		Version string   `xml:"version,attr"`
+		Atom    string   `xml:"xmlns:atom,attr"`
+		Channel Channel  `xml:"channel"`
+	}
+
+	// add 10 most recent posts to feed
+	n := len(s.Posts)
+	if n > 10 {
+		n = 10
+	}
+
+	items := make([]Item, 0, n)
+	for _, p := range s.Posts[0:n] {
+		items = append(items, Item{
+			Title:       p.Title,
+			Link:        p.Permalink,
+			Description: string(p.Content),
+			PubDate:     p.DatePublished.Format(time.RFC1123Z),
+			GUID:        p.Permalink,
+		})
+	}
+
+	feed := Feed{
+		Version: "2.0",
+		Atom:    "http://www.w3.org/2005/Atom",
+		Channel: Channel{
+			Title:         s.Title,
+			Link:          s.SiteUrl,
+			Generator:     "Gozer",
+			LastBuildDate: s.now.Format(time.RFC1123Z),
+			Items:         items,
+		},
+	}
+
+	wr, err := s.out.create("feed.xml")
+	if err != nil {
+		return err
+	}
+	defer wr.Close()
+
+	if _, err := wr.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>`)); err != nil {
+		return err
+	}
+
+	if err := xml.NewEncoder(wr).Encode(feed); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func parseConfig(s *Site, file string) error {
+	s.Markup = defaultMarkup()
+	md, err := toml.DecodeFile(file, s)
+	if err != nil {
+		return err
+	}
+
+	// code blocks are highlighted if the [markup.highlight] table is present, even if empty
+	if s.Markup.Highlight.enabled = md.IsDefined("markup", "highlight"); s.Markup.Highlight.enabled {
+		if _, err := s.Markup.Highlight.style(); err != nil {
+			return err
+		}
+	}
+
+	meta := make(map[string]any)
+	if _, err := toml.DecodeFile(file, &meta); err != nil {
+		return err
+	}
+
+	s.Meta = meta
+	s.Attrs = s.Meta
+
+	// ensure site url has trailing slash
+	if !strings.HasSuffix(s.SiteUrl, "/") {
+		s.SiteUrl += "/"
+	}
+
+	return nil
+}
+
+type PageGroup struct {
+	Key   string
+	Pages []Page
+}
+
+// Result describes a completed build.
+type Result struct {
+	// The site that was built
+	Site *Site
+
+	// Directory the site was written to
+	OutputDir string
+
+	// Number of pages written, including generated taxonomy pages
+	Pages int
+
+	// Problems that did not fail the build, such as files in content/ of an unknown type
+	Warnings []error
+
+	// Time the build took
+	Duration time.Duration
+}
+
+// newTemplates parses the templates in the templates/ directory of the given project.
+func newTemplates(rootPath string) (*template.Template, error) {
+	t, err := template.New("gozer").Funcs(template.FuncMap{
+		"HasPrefix": strings.HasPrefix,
+		"HasSuffix": strings.HasSuffix,
+		"Contains":  strings.Contains,
+		"Replace":   strings.Replace,
+		// GroupByDate groups pages in the list by the Time spec, e.g. "2006",
+		// "January", in reverse order
+		"GroupByDate": func(pages []Page, date string) []PageGroup {
+			groups := make(map[string][]Page)
+			keys := make([]string, 0)
+			for _, page := range pages {
+				key := page.DateModified.Format(date)
+				if groups[key] == nil {
+					keys = append(keys, key)
+					groups[key] = []Page{page}
+				} else {
+					groups[key] = append(groups[key], page)
+				}
+			}
+			sort.Strings(keys)
+			slices.Reverse(keys)
+			rv := make([]PageGroup, len(keys))
+			for i, key := range keys {
+				pgs := groups[key]
+				slices.Reverse(pgs)
+				rv[i] = PageGroup{
+					Key:   key,
+					Pages: groups[key],
+				}
+			}
+			return rv
+		},
+	}).ParseGlob(filepath.Join(rootPath, "templates/*.html"))
+	if err != nil {
+		return nil, err
+	}
+
+	// shortcodes are named after their path, so they are never mistaken for a page template
+	files, err := filepath.Glob(filepath.Join(rootPath, "templates/shortcodes/*.html"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := t.New("shortcodes/" + filepath.Base(file)).Parse(string(b)); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// Build reads the site in opts.RootDir and writes it to its output directory.
+//
+// If pages, templates or generated files fail, Build returns a *BuildError listing every error.
+// Unless opts.KeepGoing is set, the build then stops early and a clean build leaves the
+// output directory untouched. The returned Result is non-nil whenever the output was written.
+func Build(ctx context.Context, opts Options) (*Result, error) {
+	timeStart := time.Now()
+	rootPath := opts.RootDir
+	if opts.ConfigFile == "" {
+		opts.ConfigFile = "config.toml"
+	}
+
+	// the time is kept unset in the options of the site, so every rebuild uses its own start time
+	now := opts.Now
+	if now.IsZero() {
+		now = timeStart
+	}
+
+	templates, err := newTemplates(rootPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading templates/ directory: %w", err)
+	}
+
+	site := &Site{
+		RootDir:   rootPath,
+		options:   opts,
+		templates: templates,
+		now:       now,
+		deps:      deps{templates: analyzeTemplates(templates)},
+	}
+	site.problems.strict = opts.WarningsAsErrors
+
+	configFile := filepath.Join(rootPath, opts.ConfigFile)
+	if err := parseConfig(site, configFile); err != nil {
+		return nil, fmt.Errorf("error reading configuration file at %s: %w", configFile, err)
+	}
+	site.md = newMarkdown(site.Markup)
+
+	// the OutputDir option takes precedence over the output_dir configuration key
+	outputDir := opts.OutputDir
+	if outputDir == "" {
+		outputDir = site.OutputDir
+	}
+	if outputDir == "" {
+		outputDir = "build"
+	}
+	if !filepath.IsAbs(outputDir) {
+		outputDir = filepath.Join(rootPath, outputDir)
+	}
+	site.OutputDir = outputDir
+
+	if err := checkOutputDir(outputDir, rootPath); err != nil {
+		return nil, fmt.Errorf("error preparing output directory: %w", err)
+	}
+
+	// a clean build is written to a staging directory first, so the output directory is never incomplete
+	buildDir := outputDir
+	if opts.Clean {
+		if buildDir, err = newStagingDir(outputDir); err != nil {
+			return nil, fmt.Errorf("error creating build directory: %w", err)
+		}
+	}
+	site.out = newOutput(buildDir)
+
+	pages, err := site.build(ctx)
+	if err == nil && !opts.KeepGoing {
+		err = site.problems.err()
+	}
+	if err != nil {
+		if opts.Clean {
+			_ = os.RemoveAll(buildDir)
+		}
+		return nil, err
+	}
+
+	if opts.Clean {
+		if err := swapDir(buildDir, outputDir); err != nil {
+			return nil, fmt.Errorf("error replacing output directory: %w", err)
+		}
+	} else if err := site.out.removeStale(); err != nil {
+		site.problems.warn(fmt.Errorf("error removing stale files from output directory: %w", err))
+	}
+
+	return &Result{
+		Site:      site,
+		OutputDir: outputDir,
+		Pages:     pages,
+		Warnings:  site.problems.warnings,
+		Duration:  time.Since(timeStart),
+	}, site.problems.err()
+}
+
+// build reads the content of the site and writes all pages, feeds and static files to its output.
+// It returns the number of pages written. Errors in individual files are recorded in s.problems,
+// and stop the build after the current stage unless the KeepGoing option is set.
+func (s *Site) build(ctx context.Context) (int, error) {
+	// stop on the first error, unless we're asked to keep going
+	stopped := func() bool {
+		return !s.options.KeepGoing && s.problems.failed()
+	}
+
+	// read content
+	if err := s.readContent(filepath.Join(s.RootDir, "content")); err != nil {
+		return 0, fmt.Errorf("error reading content/: %w", err)
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if stopped() {
+		return 0, nil
+	}
+
+	var wg sync.WaitGroup
+
+	// group pages by taxonomy term
+	s.problems.stepError("taxonomies", s.collectTaxonomies())
+
+	// build each individual page, including generated taxonomy pages
+	pages := append(s.taxonomyPages(), s.Pages...)
+	for _, p := range pages {
+		wg.Add(1)
+
+		go func(p Page) {
+			defer wg.Done()
+			if ctx.Err() != nil || stopped() {
+				return
+			}
+
+			if err := s.buildPage(&p); err != nil {
+				s.problems.pageError(pageName(&p), &FileError{File: pageName(&p), Err: err})
+			}
+		}(p)
+	}
+
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if stopped() {
+		return 0, nil
+	}
+
+	// create XML sitemap
+	err := s.createSitemap()
+	if err != nil {
+		err = fmt.Errorf("error creating sitemap: %w", err)
+	}
+	s.problems.stepError("sitemap", err)
+
+	// create RSS feed
+	err = s.createRSSFeed()
+	if err != nil {
+		err = fmt.Errorf("error creating RSS feed: %w", err)
+	}
+	s.problems.stepError("feed", err)
+
+	// static files
+	static, err := s.out.copyDir(filepath.Join(s.RootDir, "public"))
+	if err != nil {
+		return 0, fmt.Errorf("error copying public/ directory: %w", err)
+	}
+	s.deps.addStatic(static)
+
+	// create redirect pages for aliases, after static files so the _redirects file includes public/_redirects
+	s.problems.stepError("aliases", s.createAliases(pages))
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return len(pages), nil
+}
+
+// pageName returns the name to use for the given page in error messages:
+// its source file, or its URL path for generated pages.
+func pageName(p *Page) string {
+	if p.Filepath != "" {
+		return p.Filepath
+	}
+	return "/" + p.UrlPath
+}
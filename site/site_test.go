@@ -0,0 +1,1884 @@
+package site
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// buildSite builds the site with the given options and fails the test if the build fails.
+func buildSite(t *testing.T, opts Options) *Result {
+	t.Helper()
+	result, err := Build(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestExampleSite(t *testing.T) {
+	_ = os.RemoveAll("../example/build/")
+	buildSite(t, Options{RootDir: "../example/", Drafts: true, Clean: true})
+
+	tests := []struct {
+		file     string
+		contains [][]byte
+	}{
+		{"index.html", [][]byte{
+			[]byte("<p>Hey, welcome on my site!</p>"),
+			[]byte("<title>My site</title>"),
+			[]byte("<li>key1: 1</li>"),
+			[]byte("<li>key2: two</li>"),
+			[]byte("<li>title: My site</li>")},
+		},
+		{"about/index.html", [][]byte{
+			[]byte("<title>About me</title>"),
+			[]byte("<li>draft: true</li>"),
+			[]byte("<li>tags: [about gozer]</li>"),
+			[]byte("<li>aliases: [/about-me/]</li>"),
+			[]byte("<li>Dolor</li>")},
+		},
+		{"hello-world/index.html", [][]byte{
+			[]byte("<title>Hello, world!</title>"),
+			[]byte("This is a blog post."),
+			[]byte(`<a rel="next" href="http://localhost:8080/blog/sections/">Sections</a>`)},
+		},
+		{"tags/index.html", [][]byte{
+			[]byte(`<li><a href="http://localhost:8080/tags/about/">about</a> (1)</li>`),
+			[]byte(`<li><a href="http://localhost:8080/tags/gozer/">gozer</a> (2)</li>`)},
+		},
+		{"tags/gozer/index.html", [][]byte{
+			[]byte("<h1>Pages tagged with gozer</h1>"),
+			[]byte(`<li><a href="http://localhost:8080/hello-world/">Hello, world!</a></li>`),
+			[]byte(`<li><a href="http://localhost:8080/about/">About me</a></li>`)},
+		},
+		{"blog/index.html", [][]byte{
+			[]byte("<h1>Blog</h1>"),
+			[]byte("<p>All posts in the blog section.</p>"),
+			[]byte(`<li><a href="http://localhost:8080/blog/sections/">Sections</a></li>`)},
+		},
+		{"blog/sections/index.html", [][]byte{
+			[]byte("<title>Sections</title>"),
+			[]byte("This post lives in the blog section."),
+			[]byte(`<a rel="prev" href="http://localhost:8080/hello-world/">Hello, world!</a>`)},
+		},
+		{"about-me/index.html", [][]byte{
+			[]byte(`<link rel="canonical" href="http://localhost:8080/about/">`),
+			[]byte(`<meta http-equiv="refresh" content="0; url=http://localhost:8080/about/">`)},
+		},
+		{"favicon.ico", nil},
+		{"feed.xml", [][]byte{
+			[]byte("<item><title>Hello, world!</title><link>http://localhost:8080/hello-world/</link>"),
+		}},
+		{"sitemap.xml", [][]byte{
+			[]byte("<url><loc>http://localhost:8080/</loc>"),
+			[]byte("<url><loc>http://localhost:8080/tags/gozer/</loc>"),
+		}},
+		{"sitemap.xsl", nil},
+	}
+
+	for _, tc := range tests {
+		content, err := os.ReadFile("../example/build/" + tc.file)
+		if err != nil {
+			t.Errorf("Expected file, got error: %s", err)
+		}
+
+		for _, e := range tc.contains {
+			if !bytes.Contains(content, e) {
+				t.Errorf("Output file %s does not have expected content %s", tc.file, e)
+			}
+		}
+
+	}
+}
+
+func TestExampleSiteWithoutDrafts(t *testing.T) {
+	_ = os.RemoveAll("../example/build/")
+	buildSite(t, Options{RootDir: "../example/"})
+
+	if _, err := os.Stat("../example/build/about/index.html"); !os.IsNotExist(err) {
+		t.Errorf("Expected draft page to be skipped, got %v", err)
+	}
+
+	content, err := os.ReadFile("../example/build/sitemap.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(content, []byte("/about/")) {
+		t.Errorf("Expected sitemap to leave out draft page")
+	}
+}
+
+func TestExampleSiteRemovesStaleFiles(t *testing.T) {
+	_ = os.RemoveAll("../example/build/")
+	buildSite(t, Options{RootDir: "../example/", Drafts: true})
+	if _, err := os.Stat("../example/build/about/index.html"); err != nil {
+		t.Fatalf("Expected draft page to be built, got %v", err)
+	}
+
+	// rebuilding in place without drafts should remove the draft page and its alias
+	buildSite(t, Options{RootDir: "../example/"})
+	for _, file := range []string{"../example/build/about/index.html", "../example/build/about", "../example/build/about-me"} {
+		if _, err := os.Stat(file); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed, got %v", file, err)
+		}
+	}
+	if _, err := os.Stat("../example/build/favicon.ico"); err != nil {
+		t.Errorf("Expected static files to be kept, got %v", err)
+	}
+
+	// a clean build replaces the output directory
+	if err := os.WriteFile("../example/build/stale.html", []byte("stale"), 0655); err != nil {
+		t.Fatal(err)
+	}
+	buildSite(t, Options{RootDir: "../example/", Clean: true})
+	if _, err := os.Stat("../example/build/stale.html"); !os.IsNotExist(err) {
+		t.Errorf("Expected stale file to be removed, got %v", err)
+	}
+	if _, err := os.Stat("../example/build/index.html"); err != nil {
+		t.Errorf("Expected index page to be built, got %v", err)
+	}
+}
+
+func TestRSSFeedBuildDate(t *testing.T) {
+	dir := t.TempDir() + "/"
+	writeFiles(t, dir, map[string]string{
+		"config.toml":                "url = \"http://localhost:8080\"\ntitle = \"Feed\"\n",
+		"templates/default.html":     "{{ .Content }}",
+		"content/2023-01-01-post.md": "+++\ntitle = \"Post\"\n+++\n",
+		"public/robots.txt":          "User-agent: *\n",
+	})
+	now := time.Date(2024, 2, 3, 4, 5, 6, 0, time.UTC)
+	result := buildSite(t, Options{RootDir: dir, Now: now})
+
+	content, err := os.ReadFile(filepath.Join(result.OutputDir, "feed.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "<lastBuildDate>Sat, 03 Feb 2024 04:05:06 +0000</lastBuildDate>"; !strings.Contains(string(content), expected) {
+		t.Errorf("expected feed to contain %q, got %s", expected, content)
+	}
+}
+
+func TestOutputDir(t *testing.T) {
+	dir := t.TempDir()
+	result := buildSite(t, Options{RootDir: "../example/", OutputDir: dir, Clean: true})
+	if result.OutputDir != dir {
+		t.Errorf("Expected output dir %s, got %s", dir, result.OutputDir)
+	}
+	if _, err := os.Stat(dir + "/index.html"); err != nil {
+		t.Errorf("Expected index page in output dir, got %v", err)
+	}
+
+	// relative output directories are resolved relative to the project root
+	defer os.RemoveAll("../example/output-test")
+	result = buildSite(t, Options{RootDir: "../example/", OutputDir: "output-test"})
+	if result.OutputDir != filepath.Join("..", "example", "output-test") {
+		t.Errorf("Expected output dir %s, got %s", "../example/output-test", result.OutputDir)
+	}
+	if _, err := os.Stat("../example/output-test/feed.xml"); err != nil {
+		t.Errorf("Expected feed in output dir, got %v", err)
+	}
+}
+
+func TestRootDirWithoutSlash(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"config.toml":                       "url = \"http://localhost:8080\"\ntitle = \"Root\"\n",
+		"templates/default.html":            "{{ .Content }}",
+		"content/2023-01-01-hello-world.md": "+++\ntitle = \"Hello\"\n+++\n\nHello.\n",
+		"public/robots.txt":                 "User-agent: *\n",
+	})
+
+	// a second build without Clean keeps every page it writes again
+	for i := 0; i < 2; i++ {
+		result := buildSite(t, Options{RootDir: dir})
+		if p := result.Site.Pages[0]; p.UrlPath != "hello-world/" {
+			t.Errorf("Expected URL path hello-world/, got %q", p.UrlPath)
+		}
+		if _, err := os.Stat(filepath.Join(result.OutputDir, "hello-world", "index.html")); err != nil {
+			t.Errorf("build %d: expected page in output dir, got %v", i, err)
+		}
+	}
+}
+
+func TestNotFoundPage(t *testing.T) {
+	dir := t.TempDir() + "/"
+	writeFiles(t, dir, map[string]string{
+		"config.toml":            "url = \"http://localhost:8080\"\ntitle = \"Not found\"\n",
+		"templates/default.html": "{{ .Content }}",
+		"content/404.md":         "+++\ntitle = \"Not found\"\n+++\n\nNot found.\n",
+		"public/robots.txt":      "User-agent: *\n",
+	})
+	result := buildSite(t, Options{RootDir: dir})
+
+	if content, err := os.ReadFile(filepath.Join(result.OutputDir, "404.html")); err != nil || string(content) != "<p>Not found.</p>\n" {
+		t.Errorf("Expected 404.html to be written, got %q (%v)", content, err)
+	}
+	if _, err := os.Stat(filepath.Join(result.OutputDir, "404", "index.html")); !os.IsNotExist(err) {
+		t.Errorf("Expected no 404/index.html, got %v", err)
+	}
+	if sitemap, _ := os.ReadFile(filepath.Join(result.OutputDir, "sitemap.xml")); strings.Contains(string(sitemap), "404") {
+		t.Errorf("Expected 404 page to be left out of the sitemap, got %s", sitemap)
+	}
+}
+
+func TestOutputCreate(t *testing.T) {
+	out := newOutput(t.TempDir())
+	for _, name := range []string{"../escape.html", "/abs/index.html", filepath.Join("a", "..", "..", "b.html")} {
+		if _, err := out.create(name); err == nil {
+			t.Errorf("Expected error creating %q outside the output directory, got nil", name)
+		}
+	}
+}
+
+func TestCheckOutputDir(t *testing.T) {
+	tests := []struct {
+		dir   string
+		root  string
+		valid bool
+	}{
+		{dir: "build", root: "../example/", valid: true},
+		{dir: "../example/build", root: "../example/", valid: true},
+		{dir: "../example", root: "../example/", valid: false},
+		{dir: "../example/content", root: "../example/", valid: false},
+		{dir: "../example/public/out", root: "../example/", valid: false},
+		{dir: "../example/content/out", root: "../example/", valid: false},
+		{dir: "../example/templates/out", root: "../example/", valid: false},
+		{dir: "../example/public-out", root: "../example/", valid: true},
+		{dir: "..", root: "../example/", valid: false},
+		{dir: "/", root: "../example/", valid: false},
+	}
+
+	for _, tc := range tests {
+		if err := checkOutputDir(tc.dir, tc.root); (err == nil) != tc.valid {
+			t.Errorf("checkOutputDir(%q, %q): expected valid %v, got %v", tc.dir, tc.root, tc.valid, err)
+		}
+	}
+
+	// a build into a source directory fails before writing anything
+	for _, dir := range []string{"public/out", "content/out"} {
+		if _, err := Build(context.Background(), Options{RootDir: "../example/", OutputDir: dir}); err == nil {
+			t.Errorf("Build with output directory %s: expected error, got nil", dir)
+		}
+		if _, err := os.Stat(filepath.Join("../example", dir)); !os.IsNotExist(err) {
+			t.Errorf("Build with output directory %s: expected nothing to be written, got %v", dir, err)
+		}
+	}
+}
+
+func TestIsPublished(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-24 * time.Hour)
+	future := now.Add(24 * time.Hour)
+
+	tests := []struct {
+		page     Page
+		options  Options
+		expected bool
+	}{
+		{page: Page{}, expected: true},
+		{page: Page{Draft: true}, expected: false},
+		{page: Page{Draft: true}, options: Options{Drafts: true}, expected: true},
+		{page: Page{PublishDate: past}, expected: true},
+		{page: Page{PublishDate: future}, expected: false},
+		{page: Page{DatePublished: future}, expected: false},
+		{page: Page{PublishDate: future}, options: Options{Future: true}, expected: true},
+		{page: Page{ExpiryDate: future}, expected: true},
+		{page: Page{ExpiryDate: past}, expected: false},
+		{page: Page{ExpiryDate: past}, options: Options{Expired: true}, expected: true},
+	}
+
+	for i, tc := range tests {
+		s := Site{options: tc.options, now: now}
+		if got := s.isPublished(&tc.page); got != tc.expected {
+			t.Errorf("test %d: expected %v, got %v", i, tc.expected, got)
+		}
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	tests := map[string]string{
+		"gozer":          "gozer",
+		"Static Sites":   "static-sites",
+		"  C++ & Go!  ":  "c-go",
+		"Crème brûlée":   "crème-brûlée",
+		"already-a-slug": "already-a-slug",
+	}
+
+	for input, expected := range tests {
+		if got := slugify(input); got != expected {
+			t.Errorf("slugify(%q): expected %q, got %q", input, expected, got)
+		}
+	}
+}
+
+func TestCollectTaxonomies(t *testing.T) {
+	s := Site{
+		SiteUrl:       "http://localhost:8080/",
+		TaxonomyNames: []string{"tags", "categories"},
+		Pages: []Page{
+			{Title: "One", Meta: map[string]any{"tags": []any{"Go", "web"}, "categories": "code"}},
+			{Title: "Two", Meta: map[string]any{"tags": []any{"go"}}},
+			{Title: "Three"},
+		},
+	}
+	if err := s.collectTaxonomies(); err != nil {
+		t.Fatal(err)
+	}
+
+	tags := s.Taxonomies["tags"]
+	if len(tags.Terms) != 2 {
+		t.Fatalf("expected 2 tags, got %d", len(tags.Terms))
+	}
+
+	goTerm := tags.Get("go")
+	if goTerm == nil || len(goTerm.Pages) != 2 {
+		t.Fatalf("expected term go with 2 pages, got %v", goTerm)
+	}
+
+	if goTerm.Permalink != "http://localhost:8080/tags/go/" {
+		t.Errorf("invalid term permalink, got %v", goTerm.Permalink)
+	}
+
+	if got := s.Taxonomies["categories"].Get("code"); got == nil || got.Pages[0].Title != "One" {
+		t.Errorf("expected category code with page One, got %v", got)
+	}
+}
+
+func TestCollectTaxonomiesSlugCollision(t *testing.T) {
+	s := Site{
+		TaxonomyNames: []string{"tags"},
+		Pages: []Page{
+			{Filepath: "a.md", Meta: map[string]any{"tags": []any{"C++", "Go"}}},
+			{Filepath: "b.md", Meta: map[string]any{"tags": []any{"C", "go"}}},
+		},
+	}
+
+	err := s.collectTaxonomies()
+	expected := `b.md: tags term "C" has the same URL /tags/c/ as term "C++"`
+	if err == nil || err.Error() != expected {
+		t.Errorf("expected error %q, got %v", expected, err)
+	}
+
+	tags := s.Taxonomies["tags"]
+	if c := tags.Get("C++"); c == nil || len(c.Pages) != 1 || c.Pages[0].Filepath != "a.md" {
+		t.Errorf("expected term C++ with page a.md, got %v", c)
+	}
+	if g := tags.Get("go"); g == nil || len(g.Pages) != 2 {
+		t.Errorf("expected term Go with 2 pages, got %v", g)
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	s := Site{
+		SiteUrl: "http://localhost:8080/",
+		Posts:   make([]Page, 5),
+	}
+
+	p := &Page{UrlPath: "blog/", Paginate: 2}
+	paginators := s.paginate(p)
+	if len(paginators) != 3 {
+		t.Fatalf("expected 3 pages, got %d", len(paginators))
+	}
+
+	tests := []struct {
+		urlPath string
+		items   int
+		prevUrl string
+		nextUrl string
+	}{
+		{"blog/", 2, "", "http://localhost:8080/blog/page/2/"},
+		{"blog/page/2/", 2, "http://localhost:8080/blog/", "http://localhost:8080/blog/page/3/"},
+		{"blog/page/3/", 1, "http://localhost:8080/blog/page/2/", ""},
+	}
+
+	for i, tc := range tests {
+		pg := paginators[i]
+		if pg.PageNumber != i+1 || pg.TotalPages != 3 || pg.TotalItems != 5 {
+			t.Errorf("page %d: invalid counts %d/%d/%d", i+1, pg.PageNumber, pg.TotalPages, pg.TotalItems)
+		}
+		if pg.UrlPath != tc.urlPath {
+			t.Errorf("page %d: expected url path %v, got %v", i+1, tc.urlPath, pg.UrlPath)
+		}
+		if len(pg.Items) != tc.items {
+			t.Errorf("page %d: expected %d items, got %d", i+1, tc.items, len(pg.Items))
+		}
+		if pg.PrevUrl != tc.prevUrl || pg.NextUrl != tc.nextUrl {
+			t.Errorf("page %d: expected prev/next %v %v, got %v %v", i+1, tc.prevUrl, tc.nextUrl, pg.PrevUrl, pg.NextUrl)
+		}
+	}
+
+	// an empty list still results in a single page
+	s.Posts = nil
+	if got := len(s.paginate(p)); got != 1 {
+		t.Errorf("expected 1 page for empty list, got %d", got)
+	}
+}
+
+func TestLinkSections(t *testing.T) {
+	s := Site{}
+	for _, file := range []string{
+		"content/index.md",
+		"content/blog/_index.md",
+		"content/blog/2023-11-01-first.md",
+		"content/blog/2023-11-02-second.md",
+		"content/blog/series/_index.md",
+		"content/blog/series/part-one.md",
+		"content/docs/intro.md",
+	} {
+		p := Page{Filepath: file}
+		p.UrlPath, p.DatePublished = parseFilename(file, "")
+		s.setSection(&p)
+		s.Pages = append(s.Pages, p)
+	}
+	s.linkSections()
+
+	tests := []struct {
+		page     int
+		section  string
+		parent   string
+		children []string
+	}{
+		{page: 0, section: "", parent: ""},
+		{page: 1, section: "blog", parent: "", children: []string{"blog/second/", "blog/first/", "blog/series/"}},
+		{page: 2, section: "blog", parent: "blog/"},
+		{page: 4, section: "blog", parent: "blog/", children: []string{"blog/series/part-one/"}},
+		{page: 5, section: "blog", parent: "blog/series/"},
+		{page: 6, section: "docs", parent: ""},
+	}
+
+	for _, tc := range tests {
+		p := s.Pages[tc.page]
+		if p.Section != tc.section {
+			t.Errorf("%s: expected section %q, got %q", p.Filepath, tc.section, p.Section)
+		}
+
+		parent := ""
+		if p.Parent != nil {
+			parent = p.Parent.UrlPath
+		}
+		if parent != tc.parent {
+			t.Errorf("%s: expected parent %q, got %q", p.Filepath, tc.parent, parent)
+		}
+
+		children := make([]string, 0, len(p.Children))
+		for _, c := range p.Children {
+			children = append(children, c.UrlPath)
+		}
+		if fmt.Sprint(children) != fmt.Sprint(tc.children) {
+			t.Errorf("%s: expected children %v, got %v", p.Filepath, tc.children, children)
+		}
+	}
+}
+
+func TestLinkPosts(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2023, 11, d, 0, 0, 0, 0, time.UTC) }
+	s := Site{
+		Posts: []Page{
+			{Filepath: "a", Section: "blog", DatePublished: day(1)},
+			{Filepath: "b", Section: "news", DatePublished: day(2)},
+			{Filepath: "c", Section: "blog", DatePublished: day(3)},
+			{Filepath: "d", Section: "blog", DatePublished: day(4), Weight: 1},
+		},
+	}
+	sort.SliceStable(s.Posts, func(i, j int) bool {
+		return lessPage(&s.Posts[i], &s.Posts[j])
+	})
+	s.linkPosts()
+
+	name := func(p *Page) string {
+		if p == nil {
+			return ""
+		}
+		return p.Filepath
+	}
+
+	tests := []struct {
+		file                                     string
+		prev, next, prevInSection, nextInSection string
+	}{
+		{file: "d", prev: "c", next: "", prevInSection: "c", nextInSection: ""},
+		{file: "c", prev: "b", next: "d", prevInSection: "a", nextInSection: "d"},
+		{file: "b", prev: "a", next: "c", prevInSection: "", nextInSection: ""},
+		{file: "a", prev: "", next: "b", prevInSection: "", nextInSection: "c"},
+	}
+
+	for i, tc := range tests {
+		if s.Posts[i].Filepath != tc.file {
+			t.Fatalf("expected post %d to be %s, got %s", i, tc.file, s.Posts[i].Filepath)
+		}
+
+		nb := s.neighbours[tc.file]
+		got := []string{name(nb.prev), name(nb.next), name(nb.prevInSection), name(nb.nextInSection)}
+		expected := []string{tc.prev, tc.next, tc.prevInSection, tc.nextInSection}
+		if fmt.Sprint(got) != fmt.Sprint(expected) {
+			t.Errorf("%s: expected neighbours %q, got %q", tc.file, expected, got)
+		}
+	}
+}
+
+func TestCollectAliases(t *testing.T) {
+	pages := []Page{
+		{Filepath: "a.md", UrlPath: "a/", Aliases: []string{"/old-a/", "old/a.html", "/b", "/blog/page/2"}},
+		{Filepath: "b.md", UrlPath: "b/", Aliases: []string{"old-a", "/old-b/"}},
+		{Filepath: "blog.md", UrlPath: "blog/", Paginate: 1},
+	}
+	s := &Site{Posts: []Page{{Title: "One"}, {Title: "Two"}}}
+
+	aliases, err := s.collectAliases(pages)
+	var got []string
+	for _, a := range aliases {
+		got = append(got, a.UrlPath+" "+a.Page.UrlPath)
+	}
+	if expected := []string{"old-a/ a/", "old/a.html a/", "old-b/ b/"}; fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Errorf("expected aliases %v, got %v", expected, got)
+	}
+
+	if err == nil {
+		t.Fatal("expected collision errors, got nil")
+	}
+	for _, expected := range []string{
+		"a.md: alias /b/ collides with page b.md",
+		"b.md: alias /old-a/ collides with alias of a.md",
+		"a.md: alias /blog/page/2/ collides with page blog.md",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected error %q, got %v", expected, err)
+		}
+	}
+}
+
+func TestRedirectsFile(t *testing.T) {
+	dir := t.TempDir() + "/"
+	writeFiles(t, dir, map[string]string{
+		"config.toml":            "url = \"http://localhost:8080\"\ntitle = \"Redirects\"\nredirects_file = true\n",
+		"templates/default.html": "{{ .Content }}",
+		"content/a.md":           "+++\ntitle = \"A\"\naliases = [\"/old-a/\"]\n+++\n",
+		"public/_redirects":      "/old /new 302",
+	})
+	result := buildSite(t, Options{RootDir: dir})
+
+	tests := []struct {
+		name     string
+		files    map[string]string
+		remove   bool
+		expected string
+	}{
+		{name: "build", expected: "/old /new 302\n/old-a/ /a/ 301\n"},
+		{name: "edit rules", files: map[string]string{"public/_redirects": "/x /y 302\n"}, expected: "/x /y 302\n/old-a/ /a/ 301\n"},
+		{name: "remove rules", remove: true, expected: "/old-a/ /a/ 301\n"},
+	}
+	for _, tc := range tests {
+		if tc.name != "build" {
+			writeFiles(t, dir, tc.files)
+			if tc.remove {
+				if err := os.Remove(filepath.Join(dir, "public", "_redirects")); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			next, err := result.Site.Rebuild(context.Background(), []string{filepath.Join(dir, "public", "_redirects")})
+			if err != nil {
+				t.Fatalf("%s: %s", tc.name, err)
+			}
+			result = next
+		}
+
+		content, err := os.ReadFile(filepath.Join(result.OutputDir, "_redirects"))
+		if err != nil || string(content) != tc.expected {
+			t.Errorf("%s: expected _redirects to contain %q, got %q (%v)", tc.name, tc.expected, content, err)
+		}
+	}
+}
+
+func TestParseConfigFile(t *testing.T) {
+	s := Site{}
+	if err := parseConfig(&s, "../example/config.toml"); err != nil {
+		t.Errorf("error parsing config file: %s", err)
+	}
+
+	expectedSiteUrl := "http://localhost:8080/"
+	expectedTitle := "My website"
+	if s.SiteUrl != expectedSiteUrl {
+		t.Errorf("invalid site url. expected %v, got %v", expectedSiteUrl, s.SiteUrl)
+	}
+
+	if s.Title != expectedTitle {
+		t.Errorf("invalid site title. expected %v, got %v", expectedTitle, s.Title)
+	}
+}
+
+func TestParseConfigMetaAndAttrsAlias(t *testing.T) {
+	s := Site{}
+	if err := parseConfig(&s, "../example/config.toml"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := s.Meta["key1"]; fmt.Sprint(got) != "1" {
+		t.Errorf("invalid site attr key1. expected %v, got %v", 1, got)
+	}
+
+	if got := s.Meta["key2"]; got != "two" {
+		t.Errorf("invalid site attr key2. expected %q, got %v", "two", got)
+	}
+
+	if got := s.Meta["title"]; got != "My website" {
+		t.Errorf("invalid site attr title. expected %q, got %v", "My website", got)
+	}
+
+	if got := s.Meta["url"]; got != "http://localhost:8080" {
+		t.Errorf("invalid site attr url. expected %q, got %v", "http://localhost:8080", got)
+	}
+
+	if got := s.Attrs["key1"]; fmt.Sprint(got) != "1" {
+		t.Errorf("invalid site alias attr key1. expected %v, got %v", 1, got)
+	}
+
+	s.Meta["alias"] = "yes"
+	if got := s.Attrs["alias"]; got != "yes" {
+		t.Errorf("expected attrs alias to reflect meta writes, got %v", got)
+	}
+
+	s.Attrs["alias2"] = "yes"
+	if got := s.Meta["alias2"]; got != "yes" {
+		t.Errorf("expected meta to reflect attrs alias writes, got %v", got)
+	}
+}
+
+func TestParseFrontMatter(t *testing.T) {
+	p := &Page{
+		Filepath: "../example/content/index.md",
+	}
+
+	if err := parseFrontMatter(p); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedTitle := "My site"
+	if p.Title != expectedTitle {
+		t.Errorf("Invalid title. Expected %v, got %v", expectedTitle, p.Title)
+	}
+
+	if got := p.Meta["title"]; got != expectedTitle {
+		t.Errorf("Invalid front matter title attr. Expected %v, got %v", expectedTitle, got)
+	}
+
+	if got := p.Attrs["title"]; got != expectedTitle {
+		t.Errorf("Invalid front matter title alias attr. Expected %v, got %v", expectedTitle, got)
+	}
+}
+
+func TestParseFrontMatterDjot(t *testing.T) {
+	p := &Page{
+		Filepath: "../example/content/djot.dj",
+	}
+
+	if err := parseFrontMatter(p); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedTitle := "Multiple markup support"
+	if p.Title != expectedTitle {
+		t.Errorf("Invalid title. Expected %v, got %v", expectedTitle, p.Title)
+	}
+}
+
+func TestParseFrontMatterMetaAndAttrsAlias(t *testing.T) {
+	p := &Page{
+		Filepath: "../example/content/about.md",
+	}
+
+	if err := parseFrontMatter(p); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := p.Meta["draft"]; got != true {
+		t.Errorf("Invalid draft attr. Expected true, got %v", got)
+	}
+
+	if got := p.Meta["title"]; got != "About me" {
+		t.Errorf("Invalid title attr. Expected %q, got %v", "About me", got)
+	}
+
+	if got := fmt.Sprint(p.Meta["tags"]); got != "[about gozer]" {
+		t.Errorf("Invalid tags attr. Expected %q, got %v", "[about gozer]", got)
+	}
+
+	p.Meta["alias"] = "yes"
+	if got := p.Attrs["alias"]; got != "yes" {
+		t.Errorf("expected attrs alias to reflect meta writes, got %v", got)
+	}
+
+	p.Attrs["alias2"] = "yes"
+	if got := p.Meta["alias2"]; got != "yes" {
+		t.Errorf("expected meta to reflect attrs alias writes, got %v", got)
+	}
+}
+
+func TestParseFrontMatterLong(t *testing.T) {
+	description := strings.Repeat("A very long description. ", 100)
+	file := t.TempDir() + "/long.md"
+	content := "+++\ntitle = \"Long\"\ndescription = \"" + description + "\"\nmarker = \"+++\"\n\n[[authors]]\nname = \"Jane\"\n+++\n\nContent.\n"
+	if err := os.WriteFile(file, []byte(content), 0655); err != nil {
+		t.Fatal(err)
+	}
+
+	p := &Page{Filepath: file}
+	if err := parseFrontMatter(p); err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Title != "Long" {
+		t.Errorf("Invalid title. Expected %q, got %q", "Long", p.Title)
+	}
+	if p.Meta["description"] != description {
+		t.Errorf("Invalid description attr, got %v", p.Meta["description"])
+	}
+	if p.Meta["marker"] != "+++" {
+		t.Errorf("Invalid marker attr, got %v", p.Meta["marker"])
+	}
+	if string(p.source) != "\nContent.\n" {
+		t.Errorf("Invalid body, got %q", p.source)
+	}
+}
+
+func TestParseFrontMatterErrors(t *testing.T) {
+	tests := []struct {
+		content  string
+		expected string
+	}{
+		{"+++\ntitle = \"Unclosed\"\n\nContent.\n", ":1: missing closing front-matter identifier"},
+		{"+++\ntitle = \"Syntax\"\ndraft = \n+++\n", ":3: toml: (last key \"draft\"): expected value"},
+		{"+++\ntitle = \"Type\"\n\nweight = \"heavy\"\n+++\n", ":4: toml: (last key \"weight\"): incompatible types"},
+		{"---\ntitle: Unclosed\n", ":1: missing closing front-matter identifier"},
+		{"---\ntitle: Syntax\nkey: value: other\n---\n", ":3: yaml: mapping values are not allowed"},
+		{"---\ntitle: Type\nweight: heavy\n---\n", ":2: invalid front matter: toml: (last key \"weight\"): incompatible types"},
+		{"---\ntitle: Range\nweight: 18446744073709551615\n---\n", ":2: invalid front matter: 18446744073709551615 is out of range for int64"},
+		{"{\n  \"title\": \"Syntax\",\n  \"draft\": tru\n}\n", ":3: json: invalid character"},
+	}
+
+	dir := t.TempDir()
+	for i, tc := range tests {
+		file := fmt.Sprintf("%s/%d.md", dir, i)
+		if err := os.WriteFile(file, []byte(tc.content), 0655); err != nil {
+			t.Fatal(err)
+		}
+
+		err := parseFrontMatter(&Page{Filepath: file})
+		if err == nil || !strings.HasPrefix(err.Error(), file+tc.expected) {
+			t.Errorf("Expected error starting with %q, got %v", file+tc.expected, err)
+		}
+	}
+}
+
+func TestParseFrontMatterFormats(t *testing.T) {
+	tests := map[string]string{
+		"toml.md": "+++\ntitle = \"Formats\"\ndraft = true\nweight = 3\nrating = 4.5\npublishDate = 2023-11-23T10:00:00Z\ntags = [\"a\", \"b\"]\n\n[author]\nname = \"Jane\"\n+++\n\nContent.\n",
+		"yaml.md": "---\ntitle: Formats\ndraft: true\nweight: 3\nrating: 4.5\npublishDate: 2023-11-23T10:00:00Z\ntags: [a, b]\nauthor:\n  name: Jane\nempty: ~\n---\n\nContent.\n",
+		"toml.dj": "+++\ntitle = \"Formats\"\ndraft = true\nweight = 3\nrating = 4.5\npublishDate = 2023-11-23T10:00:00Z\ntags = [\"a\", \"b\"]\n\n[author]\nname = \"Jane\"\n+++\n\nContent.\n",
+		"yaml.dj": "---\ntitle: Formats\ndraft: true\nweight: 3\nrating: 4.5\npublishDate: 2023-11-23T10:00:00Z\ntags: [a, b]\nauthor:\n  name: Jane\nempty: ~\n---\n\nContent.\n",
+		"json.md": "{\n  \"title\": \"Formats\",\n  \"draft\": true,\n  \"weight\": 3,\n  \"rating\": 4.5,\n  \"publishDate\": \"2023-11-23T10:00:00Z\",\n  \"tags\": [\"a\", \"b\"],\n  \"author\": {\"name\": \"Jane\"},\n  \"empty\": null\n}\n\nContent.\n",
+		"json.dj": "{\n  \"title\": \"Formats\",\n  \"draft\": true,\n  \"weight\": 3,\n  \"rating\": 4.5,\n  \"publishDate\": \"2023-11-23T10:00:00Z\",\n  \"tags\": [\"a\", \"b\"],\n  \"author\": {\"name\": \"Jane\"},\n  \"empty\": null\n}\n\nContent.\n",
+	}
+
+	dir := t.TempDir()
+	publishDate := time.Date(2023, 11, 23, 10, 0, 0, 0, time.UTC)
+	for name, content := range tests {
+		file := dir + "/" + name
+		if err := os.WriteFile(file, []byte(content), 0655); err != nil {
+			t.Fatal(err)
+		}
+
+		p := &Page{Filepath: file}
+		if err := parseFrontMatter(p); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		if p.Title != "Formats" || !p.Draft || p.Weight != 3 || !p.PublishDate.Equal(publishDate) {
+			t.Errorf("%s: invalid page fields %q %v %v %v", name, p.Title, p.Draft, p.Weight, p.PublishDate)
+		}
+
+		expected := map[string]string{
+			"title":       "string Formats",
+			"draft":       "bool true",
+			"weight":      "int64 3",
+			"rating":      "float64 4.5",
+			"publishDate": "time.Time 2023-11-23 10:00:00 +0000 UTC",
+			"tags":        "[]interface {} [a b]",
+			"author":      "map[string]interface {} map[name:Jane]",
+		}
+		if len(p.Meta) != len(expected) {
+			t.Errorf("%s: expected %d meta keys, got %v", name, len(expected), p.Meta)
+		}
+		for key, value := range expected {
+			if got := fmt.Sprintf("%T %v", p.Meta[key], p.Meta[key]); got != value {
+				t.Errorf("%s: expected meta %s to be %q, got %q", name, key, value, got)
+			}
+		}
+
+		if string(p.source) != "\nContent.\n" {
+			t.Errorf("%s: invalid body, got %q", name, p.source)
+		}
+	}
+}
+
+func TestParseFrontMatterBrace(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		title  string
+		body   string
+	}{
+		{"attributes.dj", "{.lead}\nHello\n", "", "{.lead}\nHello\n"},
+		{"attributes.md", "{#x}\n# Title\n", "", "{#x}\n# Title\n"},
+		{"shortcode.md", "{{< figure src=\"/cat.jpg\" >}}\n", "", "{{< figure src=\"/cat.jpg\" >}}\n"},
+		{"object.md", "{\"title\": \"One line\"}\n\nContent.\n", "One line", "\nContent.\n"},
+		{"multiline.md", "{\"title\": \"Two\",\n\"draft\": true}\nContent.\n", "Two", "Content.\n"},
+	}
+
+	dir := t.TempDir()
+	for _, tc := range tests {
+		file := filepath.Join(dir, tc.name)
+		if err := os.WriteFile(file, []byte(tc.source), 0655); err != nil {
+			t.Fatal(err)
+		}
+
+		p := &Page{Filepath: file}
+		if err := parseFrontMatter(p); err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+		if p.Title != tc.title || string(p.source) != tc.body {
+			t.Errorf("%s: expected title %q and body %q, got %q and %q", tc.name, tc.title, tc.body, p.Title, p.source)
+		}
+	}
+}
+
+func TestRenderExamplePage(t *testing.T) {
+	p := &Page{
+		Filepath: "../example/content/index.md",
+	}
+
+	s := &Site{md: newMarkdown(defaultMarkup())}
+	if err := parseFrontMatter(p); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.render(p); err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Content != "<p>Hey, welcome on my site!</p>\n" {
+		t.Errorf("Invalid content. Got %v", p.Content)
+	}
+}
+
+func TestRenderExamplePageDjot(t *testing.T) {
+	p := &Page{
+		Filepath: "../example/content/djot_test.dj",
+	}
+
+	s := &Site{md: newMarkdown(defaultMarkup())}
+	if err := parseFrontMatter(p); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.render(p); err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Content != "<p>Hey, welcome on my site!</p>\n" {
+		t.Errorf("Invalid content. Got %v", p.Content)
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	markdown := []byte("```go {hl_lines=\"2\" linenos=true}\npackage main\nfunc main() {}\n```\n")
+	djot := []byte("{hl_lines=\"2\" linenos=true}\n``` go\npackage main\nfunc main() {}\n```\n")
+
+	for _, tc := range []struct {
+		name     string
+		config   HighlightConfig
+		expected []string
+		absent   []string
+	}{
+		{"disabled", HighlightConfig{}, []string{`<code class="language-go"`}, []string{"chroma"}},
+		{"classes", HighlightConfig{Classes: true, enabled: true}, []string{`<pre class="chroma">`, `<span class="kd">func</span>`, `<span class="line hl"><span class="ln">2</span>`}, []string{"style="}},
+		{"inline", HighlightConfig{Style: "monokai", enabled: true}, []string{`<pre style="`, `<span style="color:#66d9ef">func</span>`}, []string{`class="kd"`}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			markup := defaultMarkup()
+			markup.Highlight = tc.config
+			s := &Site{Markup: markup, md: newMarkdown(markup)}
+			for file, source := range map[string][]byte{"code.md": markdown, "code.dj": djot} {
+				content, err := s.convert(&Page{Filepath: file}, source)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, e := range tc.expected {
+					if !strings.Contains(content, e) {
+						t.Errorf("%s: expected %q in content, got %s", file, e, content)
+					}
+				}
+				for _, e := range tc.absent {
+					if strings.Contains(content, e) {
+						t.Errorf("%s: expected no %q in content, got %s", file, e, content)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestParseCodeInfo(t *testing.T) {
+	config := HighlightConfig{LineNumbers: true}
+	for _, tc := range []struct {
+		info     string
+		expected codeOptions
+	}{
+		{"", codeOptions{lineNumbers: true, lineStart: 1}},
+		{"go", codeOptions{lang: "go", lineNumbers: true, lineStart: 1}},
+		{"go {linenos=false}", codeOptions{lang: "go", lineStart: 1}},
+		{`go {hl_lines="2 4-5" linenostart=10}`, codeOptions{lang: "go", lineNumbers: true, lineStart: 10, highlight: [][2]int{{2, 2}, {4, 5}}}},
+		{`js{hl_lines=[1,"3-4"]}`, codeOptions{lang: "js", lineNumbers: true, lineStart: 1, highlight: [][2]int{{1, 1}, {3, 4}}}},
+	} {
+		if opts := config.parseCodeInfo(tc.info); fmt.Sprint(opts) != fmt.Sprint(tc.expected) {
+			t.Errorf("parseCodeInfo(%q): expected %v, got %v", tc.info, tc.expected, opts)
+		}
+	}
+}
+
+func TestWriteHighlightCSS(t *testing.T) {
+	dir := t.TempDir() + "/"
+	writeFiles(t, dir, map[string]string{
+		"config.toml": "[markup.highlight]\nstyle = \"monokai\"\nclasses = true\n",
+	})
+
+	var buf bytes.Buffer
+	if err := WriteHighlightCSS(&buf, Options{RootDir: dir}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), ".chroma .kd { color: #66d9ef }") {
+		t.Errorf("expected monokai stylesheet, got %s", buf.String())
+	}
+
+	writeFiles(t, dir, map[string]string{
+		"config.toml": "[markup.highlight]\nstyle = \"nope\"\n",
+	})
+	if err := WriteHighlightCSS(&buf, Options{RootDir: dir}); err == nil || !strings.Contains(err.Error(), `unknown highlight style "nope"`) {
+		t.Errorf("expected unknown style error, got %v", err)
+	}
+}
+
+func TestMarkdownConfig(t *testing.T) {
+	source := "## Title {#intro}\n\n\"Quoted\" -- text\nnext line\n\nTerm\n: Definition\n\n<b>raw</b>\n\n| a |\n|---|\n| b |\n"
+	dir := t.TempDir() + "/"
+	writeFiles(t, dir, map[string]string{
+		"config.toml":            "url = \"http://localhost:8080\"\ntitle = \"Markdown\"\n\n[markup.markdown]\ntypographer = true\ndefinition_list = true\nattributes = true\nhard_wraps = true\n",
+		"templates/default.html": "{{ .Content }}",
+		"content/site.md":        "+++\ntitle = \"Site\"\n+++\n\n" + source,
+		"content/page.md":        "+++\ntitle = \"Page\"\n\n[markup.markdown]\nunsafe = false\ngfm = false\ntypographer = false\n+++\n\n" + source,
+		"content/yaml.md":        "---\ntitle: YAML\nmarkup:\n  markdown:\n    hard_wraps: false\n---\n\n" + source,
+		"public/robots.txt":      "User-agent: *\n",
+	})
+	result := buildSite(t, Options{RootDir: dir})
+
+	tests := []struct {
+		page     string
+		expected []string
+		absent   []string
+	}{
+		{"site", []string{`<h2 id="intro">Title</h2>`, "&ldquo;Quoted&rdquo; &ndash; text<br>", "<dl>", "<b>raw</b>", "<table>"}, nil},
+		{"page", []string{`<h2 id="intro">Title</h2>`, "<dl>", "<!-- raw HTML omitted -->", "| a |"}, []string{"&ldquo;", "<b>raw</b>", "<table>"}},
+		{"yaml", []string{"&ldquo;Quoted&rdquo; &ndash; text\nnext line", "<b>raw</b>"}, []string{"<br>"}},
+	}
+	for _, tc := range tests {
+		content, err := os.ReadFile(filepath.Join(result.OutputDir, tc.page, "index.html"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range tc.expected {
+			if !strings.Contains(string(content), e) {
+				t.Errorf("%s: expected %q in content, got %s", tc.page, e, content)
+			}
+		}
+		for _, e := range tc.absent {
+			if strings.Contains(string(content), e) {
+				t.Errorf("%s: expected no %q in content, got %s", tc.page, e, content)
+			}
+		}
+	}
+}
+
+func TestTableOfContents(t *testing.T) {
+	dir := t.TempDir() + "/"
+	writeFiles(t, dir, map[string]string{
+		"config.toml":            "url = \"http://localhost:8080\"\ntitle = \"Headings\"\n\n[markup.headings]\nanchors = true\n",
+		"templates/default.html": "<nav>{{ .TableOfContents.HTML }}</nav>{{ range .TableOfContents }}[{{ .ID }}:{{ len .Children }}]{{ end }}\n{{ .Content }}",
+		"content/md.md":          "+++\ntitle = \"Markdown\"\n+++\n\n# Title\n\n## Getting *started*\n\n### Install\n\n#### Deep\n\n## Getting started\n",
+		"content/dj.dj":          "+++\ntitle = \"Djot\"\n+++\n\n# Title\n\n## Getting _started_\n\n### Install\n\n#### Deep\n\n## Getting started\n",
+		"content/levels.md":      "+++\ntitle = \"Levels\"\n\n[markup.headings]\nanchors = false\ntoc_start_level = 3\ntoc_end_level = 4\n+++\n\n## Getting started\n\n### Install\n\n#### Deep\n",
+		"public/robots.txt":      "User-agent: *\n",
+	})
+	result := buildSite(t, Options{RootDir: dir})
+
+	toc := "<nav><ul>\n<li><a href=\"#getting-started\">Getting started</a>\n<ul>\n<li><a href=\"#install\">Install</a></li>\n</ul>\n</li>\n<li><a href=\"#getting-started-1\">Getting started</a></li>\n</ul>\n</nav>[getting-started:1][getting-started-1:0]"
+	tests := []struct {
+		page     string
+		expected []string
+		absent   []string
+	}{
+		{"md", []string{toc, `<h1 id="title">Title <a class="anchor" href="#title" aria-hidden="true">#</a></h1>`, `<h4 id="deep">Deep <a class="anchor" href="#deep" aria-hidden="true">#</a></h4>`}, nil},
+		{"dj", []string{toc, `<h1 id="title">Title <a class="anchor" href="#title" aria-hidden="true">#</a></h1>`, `<h4 id="deep">Deep <a class="anchor" href="#deep" aria-hidden="true">#</a></h4>`}, nil},
+		{"levels", []string{"<nav><ul>\n<li><a href=\"#install\">Install</a>\n<ul>\n<li><a href=\"#deep\">Deep</a></li>\n</ul>\n</li>\n</ul>\n</nav>[install:1]", `<h2 id="getting-started">Getting started</h2>`}, []string{"anchor"}},
+	}
+	for _, tc := range tests {
+		content, err := os.ReadFile(filepath.Join(result.OutputDir, tc.page, "index.html"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range tc.expected {
+			if !strings.Contains(string(content), e) {
+				t.Errorf("%s: expected %q in content, got %s", tc.page, e, content)
+			}
+		}
+		for _, e := range tc.absent {
+			if strings.Contains(string(content), e) {
+				t.Errorf("%s: expected no %q in content, got %s", tc.page, e, content)
+			}
+		}
+	}
+
+	for _, p := range result.Site.Pages {
+		if p.Title == "Markdown" && p.WordCount != 7 {
+			t.Errorf("expected anchors to be left out of the word count, got %d words", p.WordCount)
+		}
+	}
+}
+
+func TestDjotConfig(t *testing.T) {
+	source := "# Title\n\n\"Quoted\" -- text...\n\n{.note}\nA [span]{#s} and :smile:.\n\n``` =html\n<div>raw</div>\n```\n"
+	dir := t.TempDir() + "/"
+	writeFiles(t, dir, map[string]string{
+		"config.toml":            "url = \"http://localhost:8080\"\ntitle = \"Djot\"\n\n[markup.djot]\nsmart_punctuation = false\n",
+		"templates/default.html": "{{ .Content }}",
+		"content/site.dj":        "+++\ntitle = \"Site\"\n+++\n\n" + source,
+		"content/page.dj":        "+++\ntitle = \"Page\"\n\n[markup.djot]\nsmart_punctuation = true\nattributes = false\nauto_heading_ids = false\n+++\n\n" + source,
+		"content/yaml.dj":        "---\ntitle: YAML\nmarkup:\n  djot:\n    smart_punctuation: true\n---\n\n" + source,
+		"public/robots.txt":      "User-agent: *\n",
+	})
+	result := buildSite(t, Options{RootDir: dir})
+
+	tests := []struct {
+		page     string
+		expected []string
+		absent   []string
+	}{
+		{"site", []string{`<h1 id="title">Title</h1>`, "<p>\"Quoted\" -- text...</p>", `<p class="note">A <span id="s">span</span> and :smile:.</p>`, "<div>raw</div>"}, []string{"&ldquo;"}},
+		{"page", []string{"<h1>Title</h1>", "<p>&ldquo;Quoted&rdquo; &ndash; text&hellip;</p>", "<p>A [span] and :smile:.</p>", "<div>raw</div>"}, []string{"note", `id="s"`}},
+		{"yaml", []string{`<h1 id="title">Title</h1>`, "<p>&ldquo;Quoted&rdquo; &ndash; text&hellip;</p>", `<p class="note">`}, nil},
+	}
+	for _, tc := range tests {
+		content, err := os.ReadFile(filepath.Join(result.OutputDir, tc.page, "index.html"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range tc.expected {
+			if !strings.Contains(string(content), e) {
+				t.Errorf("%s: expected %q in content, got %s", tc.page, e, content)
+			}
+		}
+		for _, e := range tc.absent {
+			if strings.Contains(string(content), e) {
+				t.Errorf("%s: expected no %q in content, got %s", tc.page, e, content)
+			}
+		}
+	}
+}
+
+func TestConvertDjotFootnotes(t *testing.T) {
+	source := "Text[^b] and[^a], not `[^a]`.\n\n[^a]: First note[^c].\n\n    Continued.\n\n[^b]: Second note.\n[^unused]: Not referenced.\n[^c]: Nested note.\n\nAfter the notes.\n"
+	expected := `<p>Text<a id="fnref1" href="#fn1" role="doc-noteref"><sup>1</sup></a> and<a id="fnref2" href="#fn2" role="doc-noteref"><sup>2</sup></a>, not <code>[^a]</code>.</p>
+<p>After the notes.</p>
+<section role="doc-endnotes">
+<hr>
+<ol>
+<li id="fn1">
+<p>Second note.<a href="#fnref1" role="doc-backlink">↩︎︎</a></p>
+</li>
+<li id="fn2">
+<p>First note<a id="fnref3" href="#fn3" role="doc-noteref"><sup>3</sup></a>.</p>
+<p>Continued.<a href="#fnref2" role="doc-backlink">↩︎︎</a></p>
+</li>
+<li id="fn3">
+<p>Nested note.<a href="#fnref3" role="doc-backlink">↩︎︎</a></p>
+</li>
+</ol>
+</section>
+`
+	if html := ConvertDjot([]byte(source)); html != expected {
+		t.Errorf("Invalid footnotes. Expected\n%s\ngot\n%s", expected, html)
+	}
+
+	// footnote definitions in code blocks are code
+	source = "```\n[^a]: Not a note.\n```\n"
+	if html := ConvertDjot([]byte(source)); html != "<pre><code>[^a]: Not a note.\n</code></pre>\n" {
+		t.Errorf("Invalid code block, got %s", html)
+	}
+}
+
+func TestShortcodes(t *testing.T) {
+	dir := t.TempDir() + "/"
+	writeFiles(t, dir, map[string]string{
+		"config.toml":                      "url = \"http://localhost:8080\"\ntitle = \"Shortcodes\"\n",
+		"templates/default.html":           "{{ .Content }}",
+		"templates/shortcodes/figure.html": `<figure><img src="{{ .Get "src" }}">{{ with .Get "caption" }}<figcaption>{{ . }}</figcaption>{{ end }}</figure>`,
+		"templates/shortcodes/notice.html": `<div class="{{ .Get 0 }}">{{ .InnerHTML }}</div>`,
+		"templates/shortcodes/raw.html":    `{{ .Inner }}`,
+		"templates/shortcodes/page.html":   `{{ .Page.Title }}{{ if .IsPaired }} paired{{ end }}`,
+		"templates/shortcodes/b.html":      `<b>{{ .Page.Title }}</b>`,
+		"content/md.md":                    "+++\ntitle = \"Markdown\"\n+++\n\n{{< figure src=\"/cat.jpg\" caption=`A \"cat\"` >}}\n\n{{< notice warning >}}\nSome *text* on {{< page />}}.\n{{< /notice >}}\n\nInline {{< raw >}}**bold** <i>{{< /raw >}} and {{</* figure src=\"x\" */>}}.\n\n{{< raw >}}x {{< b />}} y{{< /raw >}}\n",
+		"content/dj.dj":                    "+++\ntitle = \"Djot\"\n+++\n\n{{< figure src=/cat.jpg >}}\n\n{{< notice warning >}}\nSome _text_ on {{< page >}}.\n{{< /notice >}}\n",
+		"content/html.html":                "+++\ntitle = \"HTML\"\n+++\n\n<p>{{< page >}}</p>\n",
+		"content/safe.md":                  "+++\ntitle = \"Safe\"\n\n[markup.markdown]\nunsafe = false\n+++\n\n{{< figure src=\"/cat.jpg\" >}}\n\n{{< notice warning >}}\nOn {{< page />}} <b>raw</b>.\n{{< /notice >}}\n\n<b>raw</b> and {{< page >}} inline.\n",
+		"public/robots.txt":                "User-agent: *\n",
+	})
+	result := buildSite(t, Options{RootDir: dir})
+
+	tests := []struct {
+		page     string
+		expected []string
+	}{
+		{"md", []string{
+			`<figure><img src="/cat.jpg"><figcaption>A &#34;cat&#34;</figcaption></figure>`,
+			"<div class=\"warning\"><p>Some <em>text</em> on Markdown.</p>\n</div>",
+			"<p>Inline **bold** &lt;i&gt; and {{&lt; figure src=&quot;x&quot; &gt;}}.</p>",
+			"x <b>Markdown</b> y",
+		}},
+		{"dj", []string{
+			`<figure><img src="/cat.jpg"></figure>`,
+			"<div class=\"warning\"><p>Some <em>text</em> on Djot.</p>\n</div>",
+		}},
+		{"html", []string{"<p>HTML</p>"}},
+		{"safe", []string{
+			`<figure><img src="/cat.jpg"></figure>`,
+			"<div class=\"warning\"><p>On Safe <!-- raw HTML omitted -->raw<!-- raw HTML omitted -->.</p>\n</div>",
+			"<p><!-- raw HTML omitted -->raw<!-- raw HTML omitted --> and Safe inline.</p>",
+		}},
+	}
+	for _, tc := range tests {
+		content, err := os.ReadFile(filepath.Join(result.OutputDir, tc.page, "index.html"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range tc.expected {
+			if !strings.Contains(string(content), e) {
+				t.Errorf("%s: expected %q in content, got %s", tc.page, e, content)
+			}
+		}
+	}
+}
+
+func TestShortcodeErrors(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected string
+	}{
+		{"+++\ntitle = \"Page\"\n+++\n\nText\n\n{{< missing >}}\n", "page.md:7: unknown shortcode \"missing\""},
+		{"---\ntitle: Page\n---\nText {{< notice >}}\n{{< /notice >}}\n{{< /notice >}}\n", "page.md:6: closing shortcode \"notice\" without opening tag"},
+		{"{\"title\": \"Page\"}\n{{< notice \"unterminated >}}\n", "page.md:2: invalid arguments to shortcode \"notice\": unterminated string"},
+		{"Text\n\n{{< notice\n", "page.md:3: unclosed shortcode \"notice\""},
+		{"Text {{< fail >}}\n", "page.md:1: error executing shortcode \"fail\""},
+	}
+
+	for _, tc := range tests {
+		dir := t.TempDir() + "/"
+		writeFiles(t, dir, map[string]string{
+			"config.toml":                      "url = \"http://localhost:8080\"\ntitle = \"Shortcodes\"\n",
+			"templates/default.html":           "{{ .Content }}",
+			"templates/shortcodes/notice.html": "{{ .Inner }}",
+			"templates/shortcodes/fail.html":   "{{ .Page.Missing }}",
+			"content/page.md":                  tc.source,
+		})
+
+		_, err := Build(context.Background(), Options{RootDir: dir})
+		var buildErr *BuildError
+		if !errors.As(err, &buildErr) || len(buildErr.Errors) != 1 {
+			t.Fatalf("expected a single build error for %q, got %v", tc.source, err)
+		}
+		if msg := buildErr.Errors[0].Error(); !strings.HasPrefix(msg, dir+"content/"+tc.expected) {
+			t.Errorf("expected error %q, got %q", tc.expected, msg)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name          string
+		content       string
+		summaryLength int
+		summary       string
+		truncated     bool
+		wordCount     int
+	}{
+		{
+			name:      "more.md",
+			content:   "+++\ntitle = \"More\"\n+++\n\nFirst *paragraph*.\n\n<!--more-->\n\nSecond paragraph.\n",
+			summary:   "<p>First <em>paragraph</em>.</p>\n",
+			truncated: true,
+			wordCount: 4,
+		},
+		{
+			name:      "front-matter.md",
+			content:   "+++\ntitle = \"Front matter\"\nsummary = \"A *summary*.\"\n+++\n\nSome content.\n",
+			summary:   "<p>A <em>summary</em>.</p>\n",
+			truncated: true,
+			wordCount: 2,
+		},
+		{
+			name:      "unsafe.md",
+			content:   "+++\ntitle = \"Unsafe\"\nsummary = \"<script>x</script> Safe.\"\n\n[markup.markdown]\nunsafe = false\n+++\n\nSome content.\n",
+			summary:   "<!-- raw HTML omitted -->\n",
+			truncated: true,
+			wordCount: 2,
+		},
+		{
+			name:          "words.dj",
+			content:       "+++\ntitle = \"Words\"\n+++\n\nOne two three & four five.\n",
+			summaryLength: 4,
+			summary:       "One two three &amp;…",
+			truncated:     true,
+			wordCount:     6,
+		},
+		{
+			name:      "short.md",
+			content:   "+++\ntitle = \"Short\"\n+++\n\nShort content.\n",
+			summary:   "Short content.",
+			truncated: false,
+			wordCount: 2,
+		},
+	}
+
+	for _, tc := range tests {
+		file := dir + "/" + tc.name
+		if err := os.WriteFile(file, []byte(tc.content), 0655); err != nil {
+			t.Fatal(err)
+		}
+
+		p := &Page{Filepath: file}
+		if err := parseFrontMatter(p); err != nil {
+			t.Fatal(err)
+		}
+		s := &Site{md: newMarkdown(defaultMarkup()), SummaryLength: tc.summaryLength}
+		if err := s.render(p); err != nil {
+			t.Fatal(err)
+		}
+
+		if string(p.Summary) != tc.summary {
+			t.Errorf("%s: expected summary %q, got %q", tc.name, tc.summary, p.Summary)
+		}
+		if p.Truncated != tc.truncated {
+			t.Errorf("%s: expected truncated %v, got %v", tc.name, tc.truncated, p.Truncated)
+		}
+		if p.WordCount != tc.wordCount {
+			t.Errorf("%s: expected word count %d, got %d", tc.name, tc.wordCount, p.WordCount)
+		}
+		if p.ReadingTime != 1 {
+			t.Errorf("%s: expected reading time of 1 minute, got %d", tc.name, p.ReadingTime)
+		}
+		if bytes.Contains([]byte(p.Content), moreMarker) {
+			t.Errorf("%s: expected content without more marker, got %q", tc.name, p.Content)
+		}
+	}
+}
+
+func TestFilepathToUrlpath(t *testing.T) {
+	tests := []struct {
+		input                 string
+		expectedUrlPath       string
+		expectedDatePublished time.Time
+	}{
+		{input: "content/index.md", expectedUrlPath: "", expectedDatePublished: time.Time{}},
+		{input: "content/about.md", expectedUrlPath: "about/", expectedDatePublished: time.Time{}},
+		{input: "content/blog/index.md", expectedUrlPath: "blog/", expectedDatePublished: time.Time{}},
+		{input: "content/blog/_index.md", expectedUrlPath: "blog/", expectedDatePublished: time.Time{}},
+		{input: "content/projects/gozer.md", expectedUrlPath: "projects/gozer/", expectedDatePublished: time.Time{}},
+		{input: "content/2023-11-23-hello-world.md", expectedUrlPath: "hello-world/", expectedDatePublished: time.Date(2023, 11, 23, 0, 0, 0, 0, time.UTC)},
+		{input: "content/blog/2023-11-23-here-we-are.md", expectedUrlPath: "blog/here-we-are/", expectedDatePublished: time.Date(2023, 11, 23, 0, 0, 0, 0, time.UTC)},
+		{input: "content/blog/2023-11-23-there-we-were.dj", expectedUrlPath: "blog/there-we-were/", expectedDatePublished: time.Date(2023, 11, 23, 0, 0, 0, 0, time.UTC)},
+		{input: "content/2023-11-23-hello-sunshine.dj", expectedUrlPath: "hello-sunshine/", expectedDatePublished: time.Date(2023, 11, 23, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tc := range tests {
+		urlPath, datePublished := parseFilename(tc.input, "")
+		if urlPath != tc.expectedUrlPath {
+			t.Errorf("expected %v, got %v", tc.expectedUrlPath, urlPath)
+		}
+
+		if !datePublished.Equal(tc.expectedDatePublished) {
+			t.Errorf("expected %v, got %v", tc.expectedDatePublished, datePublished)
+		}
+	}
+}
+
+func TestResolveUrlPath(t *testing.T) {
+	s := Site{
+		Permalinks: map[string]string{
+			"blog": "/:year/:month/:day/:slug/",
+			"docs": "/documentation/:title",
+		},
+	}
+
+	tests := []struct {
+		file     string
+		page     Page
+		expected string
+	}{
+		{file: "content/about.md", expected: "about/"},
+		{file: "content/about.md", page: Page{Slug: "about-me"}, expected: "about-me/"},
+		{file: "content/projects/gozer.md", page: Page{Slug: "gozer-ssg"}, expected: "projects/gozer-ssg/"},
+		{file: "content/about.md", page: Page{Url: "/me"}, expected: "me/"},
+		{file: "content/about.md", page: Page{Url: "/"}, expected: ""},
+		{file: "content/blog/2023-11-23-hello.md", expected: "2023/11/23/hello/"},
+		{file: "content/blog/2023-11-23-hello.md", page: Page{Slug: "hi"}, expected: "2023/11/23/hi/"},
+		{file: "content/blog/2023-11-23-hello.md", page: Page{Url: "/hello/"}, expected: "hello/"},
+		{file: "content/blog/_index.md", expected: "blog/"},
+		{file: "content/docs/intro.md", page: Page{Title: "Getting Started"}, expected: "documentation/getting-started/"},
+	}
+
+	for _, tc := range tests {
+		p := tc.page
+		p.Filepath = tc.file
+		var date time.Time
+		p.UrlPath, date = parseFilename(tc.file, "")
+		if p.DatePublished.IsZero() {
+			p.DatePublished = date
+		}
+		s.setSection(&p)
+
+		if got := s.resolveUrlPath(&p); got != tc.expected {
+			t.Errorf("%s %+v: expected %q, got %q", tc.file, tc.page, tc.expected, got)
+		}
+	}
+}
+
+func TestParseFrontMatterDates(t *testing.T) {
+	file := t.TempDir() + "/2023-11-23-dates.md"
+	content := "+++\ndate = 2021-01-02T03:04:05Z\nlastmod = 2022-01-02T03:04:05Z\n+++\n"
+	if err := os.WriteFile(file, []byte(content), 0655); err != nil {
+		t.Fatal(err)
+	}
+
+	p := &Page{Filepath: file, DatePublished: time.Date(2023, 11, 23, 0, 0, 0, 0, time.UTC)}
+	if err := parseFrontMatter(p); err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC); !p.DatePublished.Equal(expected) {
+		t.Errorf("expected date published %v, got %v", expected, p.DatePublished)
+	}
+	if expected := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC); !p.DateModified.Equal(expected) {
+		t.Errorf("expected date modified %v, got %v", expected, p.DateModified)
+	}
+}
+
+func BenchmarkParseFrontMatter(b *testing.B) {
+	data := `+++
+title = "My page title"
+template = "Page template"
++++
+
+Lorem ipsum dolor sit amet, consectetur adipiscing elit. Curabitur ac pretium magna. Phasellus ut ligula vel erat dictum sollicitudin eu a dolor. Donec orci mauris, cursus eget elementum eu, tempor sed massa. Aliquam mattis ullamcorper metus, sodales fermentum lectus fringilla id. Duis dui ligula, lobortis ut leo id, semper ultricies justo. Etiam vehicula sit amet ligula vitae maximus. Aenean consectetur nisl ac est convallis, vel dictum nulla iaculis. Ut dignissim lobortis ipsum, vel molestie lectus ornare quis. In hac habitasse platea dictumst. Sed ut elementum nulla.
+
+Ut eleifend felis lacus, id condimentum purus laoreet et. Nam sodales mi cursus, porta enim aliquet, venenatis quam. Sed et quam nisl. Donec libero ex, eleifend sit amet dui at, fermentum semper sem. Donec gravida id nibh eu mollis. Fusce pellentesque gravida ipsum, sit amet sagittis tellus. Donec consectetur nulla enim. Donec quis ornare tellus. Maecenas eget imperdiet lacus. Ut imperdiet dui nisi, a tristique metus sodales vel.
+
+Proin non ex id erat feugiat imperdiet. Duis posuere finibus quam, quis blandit lorem vehicula sit amet. Fusce pulvinar commodo magna, ut sodales massa interdum at. Nam in dapibus nunc. Vestibulum nisi nisl, vestibulum ac vestibulum in, maximus vitae nibh. Nulla egestas pellentesque velit, vitae tempor massa scelerisque et. Nam nibh metus, vestibulum eu justo vitae, venenatis faucibus eros. Suspendisse sed ligula dolor. Etiam sed elit ullamcorper, placerat ex at, rutrum est. Quisque vitae dolor non metus lobortis sagittis. Donec pretium orci aliquam tortor blandit, sed consectetur massa ullamcorper. Praesent vehicula nunc quis urna tempor finibus. Etiam lectus urna, tempor eget diam ac, consequat commodo nunc. Nullam sed venenatis ante, ac mollis lorem. Nunc vitae faucibus lectus. Vivamus vel arcu justo.
+
+Quisque rhoncus elementum sapien ac semper. Sed tristique elit vel nibh semper tincidunt. Nunc feugiat massa eget magna accumsan, eu commodo tortor accumsan. Morbi porttitor metus nec tellus bibendum, in consectetur ex rhoncus. Phasellus dapibus tincidunt ligula, in posuere ligula. Praesent vestibulum porttitor lorem nec mollis. Praesent magna dui, bibendum sed malesuada a, tristique id ipsum. Phasellus non ipsum eget est vulputate rutrum non nec leo. Nam consequat lobortis lorem non accumsan. Aliquam eget elit in dolor malesuada consequat. Sed luctus bibendum arcu eu posuere. Integer nec ipsum turpis. Sed luctus risus ante, eget gravida magna auctor eget sodales sed.
+`
+
+	filepath := os.TempDir() + "/front-matter.md"
+	err := os.WriteFile(filepath, []byte(data), 0655)
+	if err != nil {
+		return
+	}
+
+	p := &Page{
+		Filepath: filepath,
+	}
+	for n := 0; n < b.N; n++ {
+		if err := parseFrontMatter(p); err != nil {
+			b.Error(err)
+		}
+	}
+}
+
+// createSyntheticSite creates a site with n posts spread over 10 sections in a temporary directory.
+func createSyntheticSite(b *testing.B, n int) string {
+	dir := b.TempDir() + "/"
+	for _, d := range []string{"content", "templates", "public"} {
+		if err := os.Mkdir(dir+d, 0755); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	files := map[string]string{
+		"config.toml":            "url = \"http://localhost:8080\"\ntitle = \"Synthetic\"\ntaxonomies = [\"tags\"]\n",
+		"templates/default.html": "<title>{{ .Title }}</title>\n{{ .Content }}\n{{ range (slice .Posts 0 10) }}{{ .Title }} {{ .Summary }}{{ end }}",
+	}
+	for i := 0; i < n; i++ {
+		date := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, i%1000)
+		name := fmt.Sprintf("content/section-%d/%s-post-%d.md", i%10, date.Format("2006-01-02"), i)
+		files[name] = fmt.Sprintf("+++\ntitle = \"Post %d\"\ntags = [\"tag-%d\", \"tag-%d\"]\n+++\n\n# Post %d\n\nLorem ipsum *dolor* sit amet, consectetur adipiscing elit.\n\n<!--more-->\n\n- Phasellus ut ligula\n- Donec orci mauris\n\nCurabitur ac pretium magna. Duis dui ligula, lobortis ut leo id, semper ultricies justo.\n", i, i%50, i%7, i)
+	}
+
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Dir(dir+name), 0755); err != nil {
+			b.Fatal(err)
+		}
+		if err := os.WriteFile(dir+name, []byte(content), 0655); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func BenchmarkReadContent10k(b *testing.B) {
+	dir := createSyntheticSite(b, 10000)
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		s := &Site{RootDir: dir, md: newMarkdown(defaultMarkup())}
+		if err := s.readContent(dir + "content"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkBuildSite10k(b *testing.B) {
+	dir := createSyntheticSite(b, 10000)
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		if _, err := Build(context.Background(), Options{RootDir: dir}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestBuildErrors(t *testing.T) {
+	if _, err := Build(context.Background(), Options{RootDir: t.TempDir()}); err == nil {
+		t.Errorf("Expected error for project without templates, got nil")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	dir := t.TempDir()
+	opts := Options{RootDir: "../example/", OutputDir: filepath.Join(dir, "build"), Clean: true}
+	if _, err := Build(ctx, opts); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("Expected staging directory to be removed, got %d entries", len(entries))
+	}
+}
+
+func TestBuildPageErrors(t *testing.T) {
+	dir := t.TempDir() + "/"
+	files := map[string]string{
+		"config.toml":            "url = \"http://localhost:8080\"\ntitle = \"Errors\"\n",
+		"templates/default.html": "<title>{{ .Title }}</title>\n{{ .Content }}",
+		"content/good.md":        "+++\ntitle = \"Good\"\n+++\n\nGood page.\n",
+		"content/template.md":    "+++\ntitle = \"Template\"\ntemplate = \"missing.html\"\n+++\n\nMissing template.\n",
+		"content/notes.txt":      "Not a page.\n",
+		"public/robots.txt":      "User-agent: *\n",
+	}
+	writeFiles(t, dir, files)
+
+	tests := []struct {
+		options  Options
+		errors   int
+		warnings int
+		written  bool
+	}{
+		{options: Options{Clean: true}, errors: 1, written: false},
+		{options: Options{Clean: true, KeepGoing: true}, errors: 1, warnings: 1, written: true},
+		{options: Options{Clean: true, KeepGoing: true, WarningsAsErrors: true}, errors: 2, written: true},
+		{options: Options{KeepGoing: true, WarningsAsErrors: true}, errors: 2, written: true},
+	}
+
+	for i, tc := range tests {
+		tc.options.RootDir = dir
+		tc.options.OutputDir = filepath.Join(t.TempDir(), "build")
+		result, err := Build(context.Background(), tc.options)
+
+		var buildErr *BuildError
+		if !errors.As(err, &buildErr) {
+			t.Fatalf("test %d: expected *BuildError, got %v", i, err)
+		}
+		if len(buildErr.Errors) != tc.errors {
+			t.Errorf("test %d: expected %d errors, got %v", i, tc.errors, buildErr.Errors)
+		}
+
+		_, statErr := os.Stat(filepath.Join(tc.options.OutputDir, "good", "index.html"))
+		if written := statErr == nil; written != tc.written {
+			t.Errorf("test %d: expected output written %v, got %v", i, tc.written, written)
+		}
+		if tc.written && (result == nil || len(result.Warnings) != tc.warnings) {
+			t.Errorf("test %d: expected result with %d warnings, got %v", i, tc.warnings, result)
+		}
+	}
+}
+
+// writeFiles writes the given files, keyed by path relative to dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0655); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRebuild(t *testing.T) {
+	dir := t.TempDir() + "/"
+	writeFiles(t, dir, map[string]string{
+		"config.toml":                  "url = \"http://localhost:8080\"\ntitle = \"Rebuild\"\n",
+		"templates/default.html":       "{{ .Content }}{{ with .Prev }}prev: {{ .Title }}{{ end }}",
+		"templates/list.html":          "{{ range .Posts }}{{ .Title }};{{ end }}",
+		"templates/shortcodes/me.html": "me",
+		"content/index.md":             "+++\ntitle = \"Home\"\ntemplate = \"list.html\"\n+++\n",
+		"content/about.md":             "+++\ntitle = \"About\"\n+++\n\nAbout {{< me >}}.\n",
+		"content/2023-01-01-first.md":  "+++\ntitle = \"First\"\n+++\n\nFirst post.\n",
+		"content/2023-01-02-second.md": "+++\ntitle = \"Second\"\n+++\n\nSecond post.\n",
+		"content/2023-01-03-third.md":  "+++\ntitle = \"Third\"\n+++\n\nThird post.\n",
+		"public/style.css":             "body {}",
+	})
+	result := buildSite(t, Options{RootDir: dir})
+
+	tests := []struct {
+		name    string
+		files   map[string]string
+		remove  []string
+		changed []string
+		pages   int
+		output  map[string]string
+	}{
+		{
+			// the post itself, the list page and the post showing it as its previous post
+			name:    "edit post",
+			files:   map[string]string{"content/2023-01-01-first.md": "+++\ntitle = \"First!\"\n+++\n\nEdited.\n"},
+			changed: []string{"content/2023-01-01-first.md"},
+			pages:   3,
+			output: map[string]string{
+				"first/index.html":  "<p>Edited.</p>\n",
+				"second/index.html": "<p>Second post.</p>\nprev: First!",
+				"index.html":        "Third;Second;First!;",
+			},
+		},
+		{
+			name:    "edit shortcode",
+			files:   map[string]string{"templates/shortcodes/me.html": "myself"},
+			changed: []string{"templates/shortcodes/me.html"},
+			pages:   2,
+			output:  map[string]string{"about/index.html": "<p>About myself.</p>\n"},
+		},
+		{
+			name:    "edit page",
+			files:   map[string]string{"content/about.md": "+++\ntitle = \"About\"\n+++\n\nAbout me.\n"},
+			changed: []string{"content/about.md"},
+			pages:   2,
+			output:  map[string]string{"about/index.html": "<p>About me.</p>\n"},
+		},
+		{
+			name:    "edit page summary",
+			files:   map[string]string{"content/about.md": "+++\ntitle = \"About\"\n+++\n\nAbout me.\n<!--more-->\nMore about me.\n"},
+			changed: []string{"content/about.md"},
+			pages:   2,
+			output:  map[string]string{"about/index.html": "<p>About me.</p>\n<p>More about me.</p>\n"},
+		},
+		{
+			// the list page shows the summary of the page, not its content
+			name:    "edit page content",
+			files:   map[string]string{"content/about.md": "+++\ntitle = \"About\"\n+++\n\nAbout me.\n<!--more-->\nEven more about me.\n"},
+			changed: []string{"content/about.md"},
+			pages:   1,
+			output:  map[string]string{"about/index.html": "<p>About me.</p>\n<p>Even more about me.</p>\n"},
+		},
+		{
+			// the list page and the posts around the removed post
+			name:    "remove post",
+			remove:  []string{"content/2023-01-02-second.md"},
+			changed: []string{"content/2023-01-02-second.md"},
+			pages:   3,
+			output: map[string]string{
+				"second/index.html": "",
+				"third/index.html":  "<p>Third post.</p>\nprev: First!",
+				"index.html":        "Third;First!;",
+			},
+		},
+		{
+			// the new post, the list page and the post before it
+			name:    "add post",
+			files:   map[string]string{"content/2023-01-04-fourth.md": "+++\ntitle = \"Fourth\"\n+++\n\nFourth post.\n"},
+			changed: []string{"content/2023-01-04-fourth.md"},
+			pages:   3,
+			output: map[string]string{
+				"fourth/index.html": "<p>Fourth post.</p>\nprev: Third",
+				"index.html":        "Fourth;Third;First!;",
+			},
+		},
+		{
+			name:    "edit template",
+			files:   map[string]string{"templates/list.html": "{{ range .Posts }}{{ .Title }},{{ end }}"},
+			changed: []string{"templates/list.html"},
+			pages:   1,
+			output:  map[string]string{"index.html": "Fourth,Third,First!,"},
+		},
+		{
+			name:    "edit static file",
+			files:   map[string]string{"public/style.css": "body { color: red; }"},
+			changed: []string{"public/style.css"},
+			pages:   0,
+			output:  map[string]string{"style.css": "body { color: red; }"},
+		},
+		{
+			name:    "edit config",
+			files:   map[string]string{"config.toml": "url = \"http://localhost:8080\"\ntitle = \"Changed\"\n"},
+			changed: []string{"config.toml"},
+			pages:   5,
+		},
+		{
+			// only the files copied from the removed directory are removed
+			name:    "remove public directory",
+			remove:  []string{"public/style.css", "public"},
+			changed: []string{"public"},
+			pages:   0,
+			output: map[string]string{
+				"style.css":  "",
+				"index.html": "Fourth,Third,First!,",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		writeFiles(t, dir, tc.files)
+		for _, name := range tc.remove {
+			if err := os.Remove(filepath.Join(dir, name)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var changed []string
+		for _, name := range tc.changed {
+			changed = append(changed, filepath.Join(dir, name))
+		}
+		next, err := result.Site.Rebuild(context.Background(), changed)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+		result = next
+
+		if result.Pages != tc.pages {
+			t.Errorf("%s: expected %d pages to be written, got %d", tc.name, tc.pages, result.Pages)
+		}
+		for name, expected := range tc.output {
+			content, err := os.ReadFile(filepath.Join(result.OutputDir, name))
+			if expected == "" {
+				if !os.IsNotExist(err) {
+					t.Errorf("%s: expected %s to be removed, got %v", tc.name, name, err)
+				}
+				continue
+			}
+			if string(content) != expected {
+				t.Errorf("%s: expected %s to contain %q, got %q", tc.name, name, expected, content)
+			}
+		}
+	}
+}
+
+func TestRebuildSchedule(t *testing.T) {
+	soon := time.Now().Add(500 * time.Millisecond).UTC().Format(time.RFC3339Nano)
+	dir := t.TempDir() + "/"
+	writeFiles(t, dir, map[string]string{
+		"config.toml":            "url = \"http://localhost:8080\"\ntitle = \"Schedule\"\n",
+		"templates/default.html": "{{ .Content }}",
+		"content/scheduled.md":   "+++\ntitle = \"Scheduled\"\npublishDate = " + soon + "\n+++\n\nScheduled.\n",
+		"content/expiring.md":    "+++\ntitle = \"Expiring\"\nexpiryDate = " + soon + "\n+++\n\nExpiring.\n",
+		"public/robots.txt":      "User-agent: *\n",
+	})
+	result := buildSite(t, Options{RootDir: dir})
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(result.OutputDir, name, "index.html"))
+		return err == nil
+	}
+	if exists("scheduled") || !exists("expiring") {
+		t.Fatalf("Expected only the expiring page to be written")
+	}
+
+	// a rebuild after the dates passed publishes and removes the pages, without them changing
+	time.Sleep(600 * time.Millisecond)
+	result, err := result.Site.Rebuild(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists("scheduled") || exists("expiring") {
+		t.Errorf("Expected the scheduled page to be written and the expiring page to be removed")
+	}
+}
+
+func TestRebuildKeepsErrors(t *testing.T) {
+	dir := t.TempDir() + "/"
+	writeFiles(t, dir, map[string]string{
+		"config.toml":            "url = \"http://localhost:8080\"\ntitle = \"Errors\"\ntaxonomies = [\"tags\"]\n",
+		"templates/default.html": "{{ .Content }}",
+		"content/a.md":           "+++\ntitle = \"A\n+++\n\nBroken.\n",
+		"content/b.md":           "+++\ntitle = \"B\"\n+++\n\nB.\n",
+		"public/robots.txt":      "User-agent: *\n",
+	})
+	result, err := Build(context.Background(), Options{RootDir: dir, KeepGoing: true})
+	if err == nil || result == nil {
+		t.Fatalf("expected build to fail on a.md with output written, got %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		files   map[string]string
+		changed string
+		errors  int
+	}{
+		{"edit other file", map[string]string{"content/b.md": "+++\ntitle = \"B\"\n+++\n\nEdited.\n"}, "content/b.md", 1},
+		{"break other file", map[string]string{"content/b.md": "+++\ntitle = \"B\n+++\n"}, "content/b.md", 2},
+		{"fix file", map[string]string{"content/a.md": "+++\ntitle = \"A\"\n+++\n\nFixed.\n"}, "content/a.md", 1},
+		{"fix other file", map[string]string{"content/b.md": "+++\ntitle = \"B\"\n+++\n"}, "content/b.md", 0},
+		{"tag file", map[string]string{"content/b.md": "+++\ntitle = \"B\"\ntags = [\"C++\"]\n+++\n"}, "content/b.md", 0},
+		{"add colliding tag", map[string]string{"content/c.md": "+++\ntitle = \"C\"\ntags = [\"C\"]\n+++\n"}, "content/c.md", 1},
+		{"edit template", map[string]string{"templates/default.html": "{{ .Content -}}\n"}, "templates/default.html", 1},
+		{"fix colliding tag", map[string]string{"content/c.md": "+++\ntitle = \"C\"\ntags = [\"C++\"]\n+++\n"}, "content/c.md", 0},
+		{"add colliding alias", map[string]string{"content/d.md": "+++\ntitle = \"D\"\naliases = [\"/b/\"]\n+++\n"}, "content/d.md", 1},
+		{"edit template again", map[string]string{"templates/default.html": "{{ .Content }}"}, "templates/default.html", 1},
+		{"fix colliding alias", map[string]string{"content/d.md": "+++\ntitle = \"D\"\n+++\n"}, "content/d.md", 0},
+	}
+	for _, tc := range tests {
+		writeFiles(t, dir, tc.files)
+		next, err := result.Site.Rebuild(context.Background(), []string{filepath.Join(dir, tc.changed)})
+		if next == nil {
+			t.Fatalf("%s: expected a result, got %v", tc.name, err)
+		}
+		result = next
+
+		var buildErr *BuildError
+		if tc.errors == 0 {
+			if err != nil {
+				t.Errorf("%s: expected no error, got %v", tc.name, err)
+			}
+		} else if !errors.As(err, &buildErr) || len(buildErr.Errors) != tc.errors {
+			t.Errorf("%s: expected %d errors, got %v", tc.name, tc.errors, err)
+		}
+	}
+
+	if content, err := os.ReadFile(filepath.Join(result.OutputDir, "a", "index.html")); err != nil || string(content) != "<p>Fixed.</p>\n" {
+		t.Errorf("expected fixed page to be written, got %q (%v)", content, err)
+	}
+}
+
+func TestAnalyzeTemplates(t *testing.T) {
+	tmpl := template.Must(template.New("").Parse(`
+{{ define "list.html" }}{{ template "posts" . }}{{ end }}
+{{ define "posts" }}{{ range $p := .Posts }}{{ $p.Title }}{{ end }}{{ end }}
+{{ define "post.html" }}{{ with .Next }}{{ .Title }}{{ end }}{{ end }}
+{{ define "page.html" }}{{ .Content }}{{ end }}
+{{ define "index.html" }}{{ range (index . "Pages") }}{{ .Title }}{{ end }}{{ end }}
+{{ define "feed.html" }}{{ .Content }}{{ range .Posts }}{{ .Content }}{{ end }}{{ end }}
+{{ define "cards.html" }}{{ range .Posts }}{{ template "card" . }}{{ end }}{{ end }}
+{{ define "card" }}{{ .Title }} ({{ .ReadingTime }} min){{ end }}
+{{ define "first.html" }}{{ $p := index .Posts 0 }}{{ $p.Title }} {{ $p.DateModified }}{{ end }}
+{{ define "vars.html" }}{{ $ps := .Posts }}{{ range $ps }}{{ .Content }}{{ end }}{{ end }}
+{{ define "var-cards.html" }}{{ $ps := "" }}{{ $ps = .Site.Pages }}{{ with $ps }}{{ range . }}{{ template "card" . }}{{ end }}{{ end }}{{ end }}
+{{ define "title-var.html" }}{{ $t := .Title }}{{ with $t }}{{ $.Content }}{{ end }}{{ end }}
+`))
+
+	tests := map[string]struct {
+		list        bool
+		neighbours  bool
+		listContent bool
+	}{
+		"list.html":      {list: true},
+		"posts":          {list: true},
+		"post.html":      {neighbours: true},
+		"page.html":      {},
+		"index.html":     {list: true},
+		"feed.html":      {list: true, listContent: true},
+		"cards.html":     {list: true, listContent: true},
+		"first.html":     {list: true, listContent: true},
+		"vars.html":      {list: true, listContent: true},
+		"var-cards.html": {list: true, listContent: true},
+		"title-var.html": {},
+	}
+
+	deps := analyzeTemplates(tmpl)
+	for name, expected := range tests {
+		td := deps[name]
+		if td.list != expected.list || td.neighbours != expected.neighbours || td.listContent != expected.listContent {
+			t.Errorf("%s: expected list %v, neighbours %v and list content %v, got %v, %v and %v", name, expected.list, expected.neighbours, expected.listContent, td.list, td.neighbours, td.listContent)
+		}
+	}
+	if !deps["list.html"].calls["posts"] {
+		t.Errorf("Expected list.html to call posts")
+	}
+}
+
+func BenchmarkRebuild10k(b *testing.B) {
+	dir := createSyntheticSite(b, 10000)
+	result, err := Build(context.Background(), Options{RootDir: dir})
+	if err != nil {
+		b.Fatal(err)
+	}
+	changed := []string{filepath.Join(dir, "content/section-0/2020-01-01-post-0.md")}
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		if result, err = result.Site.Rebuild(context.Background(), changed); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkRebuildContent10k edits the content of a single post, which only writes that post again,
+// as the other pages only list the titles and summaries of posts.
+func BenchmarkRebuildContent10k(b *testing.B) {
+	dir := createSyntheticSite(b, 10000)
+	result, err := Build(context.Background(), Options{RootDir: dir})
+	if err != nil {
+		b.Fatal(err)
+	}
+	file := filepath.Join(dir, "content/section-0/2020-01-01-post-0.md")
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		b.StopTimer()
+		content := fmt.Sprintf("+++\ntitle = \"Post 0\"\ntags = [\"tag-0\", \"tag-0\"]\n+++\n\n# Post 0\n\nLorem ipsum *dolor* sit amet, consectetur adipiscing elit.\n\n<!--more-->\n\nEdit %d.\n", n)
+		if err := os.WriteFile(file, []byte(content), 0655); err != nil {
+			b.Fatal(err)
+		}
+		b.StartTimer()
+
+		if result, err = result.Site.Rebuild(context.Background(), []string{file}); err != nil {
+			b.Fatal(err)
+		}
+		if result.Pages > 3 {
+			b.Fatalf("Expected only the edited post and its neighbours to be written, got %d pages", result.Pages)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package site
+
+import (
+	"bytes"
+	"html"
+	"html/template"
+	"strings"
+)
+
+var moreMarker = []byte("<!--more-->")
+
+// Default number of words in a generated summary
+const defaultSummaryLength = 70
+
+// Number of words read per minute, used to estimate reading time
+const wordsPerMinute = 200
+
+// render converts the source of the given page to HTML and derives its table of contents, summary, word count and reading time.
+// The source is released afterwards, so every page is only rendered once.
+func (s *Site) render(p *Page) error {
+	body, err := s.expandShortcodes(p, p.source, p.sourceLine)
+	p.source = nil
+	defer func() { p.shortcodes = nil }()
+	if err != nil {
+		return err
+	}
+
+	// content before the <!--more--> marker is the summary
+	var summarySource []byte
+	if before, after, found := bytes.Cut(body, moreMarker); found {
+		summarySource = before
+		body = append(before[:len(before):len(before)], after...)
+	}
+
+	content, err := s.convert(p, body)
+	if err != nil {
+		return err
+	}
+	markup, _, err := s.pageMarkup(p)
+	if err != nil {
+		return err
+	}
+
+	// anchors are not part of the text
+	text := stripTags(content)
+	content, p.TableOfContents = headings(content, markup.Headings)
+	p.Content = template.HTML(content)
+
+	words := strings.Fields(text)
+	p.WordCount = len(words)
+	p.ReadingTime = (p.WordCount + wordsPerMinute - 1) / wordsPerMinute
+
+	switch {
+	case summarySource != nil:
+		summary, err := s.convert(p, summarySource)
+		if err != nil {
+			return err
+		}
+		p.Summary = template.HTML(summary)
+		p.Truncated = true
+	case p.Summary != "":
+		// summary from front matter, written like the content
+		summary, err := s.convert(p, []byte(p.Summary))
+		if err != nil {
+			return err
+		}
+		p.Summary = template.HTML(summary)
+		p.Truncated = true
+	default:
+		summaryLength := s.SummaryLength
+		if summaryLength <= 0 {
+			summaryLength = defaultSummaryLength
+		}
+		if len(words) > summaryLength {
+			words = words[:summaryLength]
+			p.Truncated = true
+		}
+		summary := strings.Join(words, " ")
+		if p.Truncated {
+			summary += "…"
+		}
+		p.Summary = template.HTML(template.HTMLEscapeString(summary))
+	}
+
+	return nil
+}
+
+// stripTags returns the text content of the given HTML, with all tags removed and entities unescaped.
+func stripTags(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	inTag := false
+	for _, r := range s {
+		switch {
+		case r == '<':
+			inTag = true
+		case r == '>' && inTag:
+			inTag = false
+		case !inTag:
+			b.WriteRune(r)
+		}
+	}
+	return html.UnescapeString(b.String())
+}
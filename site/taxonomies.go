@@ -0,0 +1,193 @@
+package site
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Taxonomy is a classification of pages, e.g. "tags" or "categories".
+type Taxonomy struct {
+	// Name of this taxonomy, as configured in config.toml
+	Name string
+
+	// URL path for this taxonomy's index page, relative to site URL
+	UrlPath string
+
+	// The full URL to this taxonomy's index page (incl. site URL)
+	Permalink string
+
+	// All terms in this taxonomy, sorted by name
+	Terms []*Term
+}
+
+// Term is a single value of a taxonomy, e.g. the tag "golang".
+type Term struct {
+	// Name of this term, as used in front matter
+	Name string
+
+	// URL-safe version of the term name
+	Slug string
+
+	// URL path for this term's page, relative to site URL
+	UrlPath string
+
+	// The full URL to this term's page (incl. site URL)
+	Permalink string
+
+	// All pages with this term, ordered by weight and date
+	Pages []Page
+}
+
+// Get returns the term with the given name, or nil if there is none.
+func (t *Taxonomy) Get(name string) *Term {
+	slug := slugify(name)
+	for _, term := range t.Terms {
+		if term.Slug == slug {
+			return term
+		}
+	}
+	return nil
+}
+
+// slugify turns the given string into a lowercase, URL-safe path segment.
+func slugify(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(strings.TrimSpace(s)) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteRune('-')
+			dash = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
+
+// termsFromMeta returns the term names listed under the given front matter key.
+func termsFromMeta(meta map[string]any, key string) []string {
+	switch v := meta[key].(type) {
+	case string:
+		return []string{v}
+	case []string:
+		return v
+	case []any:
+		terms := make([]string, 0, len(v))
+		for _, t := range v {
+			terms = append(terms, fmt.Sprint(t))
+		}
+		return terms
+	}
+	return nil
+}
+
+// collectTaxonomies groups all pages by the terms in each configured taxonomy.
+// Terms differing only in case are the same term. Different terms with the same slug
+// would share a URL, so they are left out and returned as errors.
+func (s *Site) collectTaxonomies() error {
+	var errs []error
+	s.Taxonomies = make(map[string]*Taxonomy, len(s.TaxonomyNames))
+	for _, name := range s.TaxonomyNames {
+		tax := &Taxonomy{
+			Name:      name,
+			UrlPath:   slugify(name) + "/",
+			Permalink: s.SiteUrl + slugify(name) + "/",
+		}
+
+		terms := make(map[string]*Term)
+		for _, p := range s.Pages {
+			for _, termName := range termsFromMeta(p.Meta, name) {
+				slug := slugify(termName)
+				if slug == "" {
+					continue
+				}
+
+				term, ok := terms[slug]
+				if ok && !strings.EqualFold(strings.TrimSpace(term.Name), strings.TrimSpace(termName)) {
+					errs = append(errs, fmt.Errorf("%s: %s term %q has the same URL /%s as term %q", p.Filepath, name, termName, term.UrlPath, term.Name))
+					continue
+				}
+				if !ok {
+					term = &Term{
+						Name:      termName,
+						Slug:      slug,
+						UrlPath:   tax.UrlPath + slug + "/",
+						Permalink: tax.Permalink + slug + "/",
+					}
+					terms[slug] = term
+					tax.Terms = append(tax.Terms, term)
+				}
+				term.Pages = append(term.Pages, p)
+			}
+		}
+
+		sort.Slice(tax.Terms, func(i, j int) bool {
+			return tax.Terms[i].Slug < tax.Terms[j].Slug
+		})
+		for _, term := range tax.Terms {
+			sort.SliceStable(term.Pages, func(i, j int) bool {
+				return lessPage(&term.Pages[i], &term.Pages[j])
+			})
+		}
+
+		s.Taxonomies[name] = tax
+	}
+
+	return errors.Join(errs...)
+}
+
+// taxonomyPages returns the generated index and term pages for all taxonomies.
+func (s *Site) taxonomyPages() []Page {
+	taxonomyTemplate := "taxonomy.html"
+	if s.templates.Lookup(taxonomyTemplate) == nil {
+		taxonomyTemplate = "default.html"
+	}
+	termTemplate := "term.html"
+	if s.templates.Lookup(termTemplate) == nil {
+		termTemplate = "default.html"
+	}
+
+	var pages []Page
+	for _, name := range s.TaxonomyNames {
+		tax := s.Taxonomies[name]
+		taxPage := Page{
+			Title:     tax.Name,
+			Template:  taxonomyTemplate,
+			UrlPath:   tax.UrlPath,
+			Permalink: tax.Permalink,
+			taxonomy:  tax,
+		}
+
+		for _, term := range tax.Terms {
+			termPage := Page{
+				Title:     term.Name,
+				Template:  termTemplate,
+				Paginate:  s.Paginate,
+				UrlPath:   term.UrlPath,
+				Permalink: term.Permalink,
+				taxonomy:  tax,
+				term:      term,
+			}
+
+			// a term page was last modified when its most recently modified page was
+			for _, p := range term.Pages {
+				if p.DateModified.After(termPage.DateModified) {
+					termPage.DateModified = p.DateModified
+				}
+			}
+			if termPage.DateModified.After(taxPage.DateModified) {
+				taxPage.DateModified = termPage.DateModified
+			}
+
+			pages = append(pages, termPage)
+		}
+
+		pages = append(pages, taxPage)
+	}
+
+	return pages
+}
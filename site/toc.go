@@ -0,0 +1,125 @@
+package site
+
+import (
+	"html"
+	"html/template"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// HeadingsConfig configures the headings and table of contents of pages, from the [markup.headings] configuration table.
+type HeadingsConfig struct {
+	// Add a link to itself to every heading with an id
+	Anchors bool `toml:"anchors"`
+
+	// Lowest heading level in the table of contents. Defaults to 2.
+	TocStartLevel int `toml:"toc_start_level"`
+
+	// Highest heading level in the table of contents. Defaults to 3.
+	TocEndLevel int `toml:"toc_end_level"`
+}
+
+// TocEntry is a heading in the table of contents of a page.
+type TocEntry struct {
+	// Level of the heading, 1 for <h1>
+	Level int
+
+	// Id of the heading, to link to it with "#" + ID
+	ID string
+
+	// Text of the heading, without any markup
+	Title string
+
+	// Headings of a higher level below this one, up to the next heading of the same level
+	Children []TocEntry
+}
+
+// TableOfContents lists the headings of a page, nested by level.
+type TableOfContents []TocEntry
+
+var (
+	headingTag  = regexp.MustCompile(`(?s)<h([1-6])(\s[^>]*)?>(.*?)</h[1-6]>`)
+	idAttribute = regexp.MustCompile(`\sid="([^"]*)"`)
+)
+
+// HTML returns the table of contents as nested lists of links to the headings.
+func (toc TableOfContents) HTML() template.HTML {
+	if len(toc) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	toc.writeHTML(&b)
+	return template.HTML(b.String())
+}
+
+func (toc TableOfContents) writeHTML(b *strings.Builder) {
+	b.WriteString("<ul>\n")
+	for _, e := range toc {
+		b.WriteString(`<li><a href="#` + template.HTMLEscapeString(e.ID) + `">` + template.HTMLEscapeString(e.Title) + "</a>")
+		if len(e.Children) > 0 {
+			b.WriteString("\n")
+			TableOfContents(e.Children).writeHTML(b)
+		}
+		b.WriteString("</li>\n")
+	}
+	b.WriteString("</ul>\n")
+}
+
+// headings returns the table of contents of the given HTML content, from all headings with an id in the configured
+// range of levels. If anchors are enabled, it also returns the content with a link to itself added to those headings.
+func headings(content string, config HeadingsConfig) (string, TableOfContents) {
+	var flat []TocEntry
+	content = headingTag.ReplaceAllStringFunc(content, func(tag string) string {
+		m := headingTag.FindStringSubmatch(tag)
+		id := idAttribute.FindStringSubmatch(m[2])
+		if id == nil {
+			return tag
+		}
+
+		level, _ := strconv.Atoi(m[1])
+		if level >= config.TocStartLevel && level <= config.TocEndLevel {
+			flat = append(flat, TocEntry{
+				Level: level,
+				ID:    html.UnescapeString(id[1]),
+				Title: strings.TrimSpace(stripTags(m[3])),
+			})
+		}
+
+		if !config.Anchors {
+			return tag
+		}
+		anchor := ` <a class="anchor" href="#` + id[1] + `" aria-hidden="true">#</a>`
+		return tag[:len(tag)-len("</h1>")] + anchor + tag[len(tag)-len("</h1>"):]
+	})
+
+	toc, _ := nestHeadings(flat, 0, false)
+	return content, toc
+}
+
+// nestHeadings nests the given headings under the preceding heading of a lower level, starting at index i.
+// It returns the entries at the level of the first heading and the index after them. Nested lists end
+// at the first heading of a lower level, the top-level list takes all remaining headings.
+func nestHeadings(flat []TocEntry, i int, nested bool) (TableOfContents, int) {
+	var toc TableOfContents
+	level := 0
+	for i < len(flat) {
+		e := flat[i]
+		switch {
+		case level == 0 || e.Level == level:
+			level = e.Level
+			toc = append(toc, e)
+			i++
+		case e.Level > level:
+			toc[len(toc)-1].Children, i = nestHeadings(flat, i, true)
+		case nested:
+			return toc, i
+		default:
+			level = e.Level
+			toc = append(toc, e)
+			i++
+		}
+	}
+	return toc, i
+}